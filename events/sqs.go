@@ -10,7 +10,9 @@ type Event struct {
 		Name string `json:"name"`
 	} `json:"bucket"`
 	Object struct {
-		Key  string `json:"key"`
+		Key       string `json:"key"`
+		ETag      string `json:"eTag"`
+		VersionID string `json:"versionId"`
 	} `json:"object"`
 }
 
@@ -4,9 +4,14 @@
 package main // import "git.illumina.com/relvacode/rpm-lambda/lambdas/create-repo-metadata"
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"git.illumina.com/relvacode/rpm-lambda/events"
+	"git.illumina.com/relvacode/rpm-lambda/secrets"
 	"git.illumina.com/relvacode/rpm-lambda/setup"
 	"git.illumina.com/relvacode/rpm-lambda/storage"
 	"git.illumina.com/relvacode/rpm-lambda/yum"
@@ -14,42 +19,110 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	EnvScanCacheBucket = `LAMBDA_SCAN_CACHE_BUCKET`
+	EnvScanCachePrefix = `LAMBDA_SCAN_CACHE_PREFIX`
+	EnvStreamingMerge  = `LAMBDA_STREAMING_MERGE`
+
+	EnvMetadataTimestamp = `LAMBDA_METADATA_TIMESTAMP`
+	EnvMetadataRevision  = `LAMBDA_METADATA_REVISION`
+	EnvSourceDateEpoch   = `SOURCE_DATE_EPOCH`
+	EnvRepositoryTags    = `LAMBDA_REPOSITORY_TAGS`
+
+	EnvSignFormats = `LAMBDA_SIGN_FORMATS`
+)
+
+const (
+	// TimestampNow timestamps metadata with the time it was generated
+	TimestampNow = "now"
+	// TimestampBuild timestamps metadata with the newest build time of all packages in the repository
+	TimestampBuild = "build"
+
+	// RevisionTimestamp uses the metadata timestamp as the repository revision
+	RevisionTimestamp = "timestamp"
+	// RevisionContent derives the repository revision from the checksums of the metadata.
+	// It is not bumped: republishing identical content deliberately keeps the same revision, and any change of content
+	// changes the revision, although not to a greater value.
+	RevisionContent = "content"
+)
+
+// staleMetadata are the types of repository data that are derived from primary and filelists data.
+// They are removed whenever primary and filelists data is regenerated so that clients don't use out of date copies.
+var staleMetadata = []string{"primary_db", "filelists_db", "primary_zck", "filelists_zck"}
+
+// defaultRepository is the key of the repository tags applied to buckets without tags of their own
+const defaultRepository = "*"
+
+// scanProgressInterval is the number of bytes scanned between each progress log entry
+const scanProgressInterval = 256 << 20
+
 type LambdaFunction struct {
-	l  aws.Logger
-	s3 *storage.S3
+	l     aws.Logger
+	s3    *storage.S3
+	cache *storage.ScanCache
+
+	// streaming merges new packages into the existing metadata without loading it into memory
+	streaming bool
+
+	// timestamp is one of TimestampNow, TimestampBuild or a fixed unix timestamp
+	timestamp string
+	revision  string
+
+	// tags of each repository by bucket name
+	tags map[string]yum.Tags
+
+	// secrets signs repository metadata as it is published if set
+	secrets secrets.GPGProvider
+	formats []secrets.SignatureFormat
 }
 
-func (f *LambdaFunction) GetRepository(ctx context.Context, bucket string) (*yum.Repository, error) {
-	metadata := yum.MetadataData{
-		XMLName: xml.Name{
-			Local: "repomd",
-		},
+// Timestamp returns the timestamp of metadata generated for a repository whose newest package was built at buildTime.
+func (f *LambdaFunction) Timestamp(buildTime int64) int64 {
+	switch f.timestamp {
+	case TimestampNow:
+		return time.Now().Unix()
+	case TimestampBuild:
+		return buildTime
 	}
+	// fixed timestamps are validated on startup
+	t, _ := strconv.ParseInt(f.timestamp, 10, 64)
+	return t
+}
+
+func (f *LambdaFunction) GetMetadata(ctx context.Context, bucket string) (*yum.MetadataData, error) {
+	var metadata yum.MetadataData
 
 	_, err := f.s3.DownloadXMLObject(ctx, &metadata, bucket, storage.RepoMDXML)
 	if err != nil {
 		return nil, err
 	}
 
-	filelist := yum.FilelistData{
-		XMLName: xml.Name{
-			Local: "filelists",
-		},
+	return &metadata, nil
+}
+
+func (f *LambdaFunction) GetRepository(ctx context.Context, bucket string) (*yum.Repository, error) {
+	metadata, err := f.GetMetadata(ctx, bucket)
+	if err != nil {
+		return nil, err
 	}
 
+	var filelist yum.FilelistData
+
 	_, err = f.s3.DownloadCompressedXMLObject(ctx, &filelist, bucket, storage.FilelistXML)
 	if err != nil {
 		return nil, err
 	}
 
-	packages := yum.PackageData{
-		XMLName: xml.Name{
-			Local: "metadata",
-		},
-	}
+	var packages yum.PackageData
 
 	_, err = f.s3.DownloadCompressedXMLObject(ctx, &packages, bucket, storage.PrimaryXML)
 	if err != nil {
@@ -57,38 +130,256 @@ func (f *LambdaFunction) GetRepository(ctx context.Context, bucket string) (*yum
 	}
 
 	return &yum.Repository{
-		Metadata: &metadata,
+		Metadata: metadata,
 		Filelist: &filelist,
 		Packages: &packages,
 	}, nil
 }
 
+// PutMetadata regenerates the check-sums of the uploaded primary and filelist data and uploads the repository metadata
+// with a new revision.
+func (f *LambdaFunction) PutMetadata(ctx context.Context, bucket string, metadata *yum.MetadataData, buildTime int64, primary, filelist *storage.XMLObject) error {
+	timestamp := f.Timestamp(buildTime)
+
+	metadata.Update(storage.PrimaryXMLObject{XMLObject: *primary}.Metadata(timestamp))
+	metadata.Update(storage.FilelistXMLObject{XMLObject: *filelist}.Metadata(timestamp))
+	for _, t := range staleMetadata {
+		metadata.Remove(t)
+	}
+
+	tags, ok := f.tags[bucket]
+	if !ok {
+		tags, ok = f.tags[defaultRepository]
+	}
+	if ok {
+		metadata.SetTags(tags)
+	}
+
+	switch f.revision {
+	case RevisionContent:
+		// a digest cannot be ordered, so content revisions are not bumped
+		metadata.Revision = metadata.ContentRevision()
+	default:
+		metadata.BumpRevision(strconv.FormatInt(timestamp, 10))
+	}
+
+	if f.secrets != nil {
+		return f.PutSignedMetadata(ctx, bucket, metadata)
+	}
+	return f.s3.UploadXMLObject(ctx, metadata, bucket, storage.RepoMDXML)
+}
+
+// PutSignedMetadata signs and uploads the repository metadata.
+// Signatures are uploaded first so that clients never see a new repomd.xml before its signature.
+// The ETag of repomd.xml is only known once it has been uploaded, so the signatures are then uploaded again
+// recording it, as sign-repo-metadata does, so that sign-repo-metadata finds them up to date.
+func (f *LambdaFunction) PutSignedMetadata(ctx context.Context, bucket string, metadata *yum.MetadataData) error {
+	data, err := storage.MarshalXMLObject(metadata)
+	if err != nil {
+		return err
+	}
+
+	key, err := f.secrets.LoadPrivateKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "load signing key")
+	}
+
+	signatures := make([][]byte, len(f.formats))
+	for i, format := range f.formats {
+		var b bytes.Buffer
+		err = format.Sign(key, bytes.NewReader(data), &b)
+		if err != nil {
+			return err
+		}
+		signatures[i] = b.Bytes()
+
+		err = f.s3.UploadObject(ctx, bytes.NewReader(signatures[i]), bucket, format.Key(storage.RepoMDXML), format.ContentType)
+		if err != nil {
+			return err
+		}
+	}
+
+	version, err := f.s3.UploadObjectVersion(ctx, bytes.NewReader(data), bucket, storage.RepoMDXML, "text/xml", storage.UploadOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i, format := range f.formats {
+		err = f.s3.UploadObjectWithOptions(ctx, bytes.NewReader(signatures[i]), bucket, format.Key(storage.RepoMDXML), format.ContentType, storage.UploadOptions{
+			Metadata: map[string]*string{
+				storage.MetadataSignedETag: aws.String(strings.Trim(version.ETag, `"`)),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *LambdaFunction) PutRepository(ctx context.Context, bucket string, repo *yum.Repository) error {
 	primary, err := f.s3.UploadCompressedXMLObject(ctx, repo.Packages, bucket, storage.PrimaryXML)
 	if err != nil {
 		return err
 	}
 
-	// Regenerate package data check-sums
-	repo.Metadata.Update(storage.PrimaryXMLObject{XMLObject: *primary}.Metadata())
-
 	filelist, err := f.s3.UploadCompressedXMLObject(ctx, repo.Filelist, bucket, storage.FilelistXML)
 	if err != nil {
 		return err
 	}
 
-	// Regenerate filelist data check-sums
-	repo.Metadata.Update(storage.FilelistXMLObject{XMLObject: *filelist}.Metadata())
+	return f.PutMetadata(ctx, bucket, repo.Metadata, repo.Packages.BuildTime(), primary, filelist)
+}
 
-	err = f.s3.UploadXMLObject(ctx, repo.Metadata, bucket, storage.RepoMDXML)
+// streamMetadata calls fn with the decompressed contents of a metadata object, or with nil if the object does not exist.
+func (f *LambdaFunction) streamMetadata(ctx context.Context, bucket, key string, fn func(r io.Reader) error) error {
+	found, r, err := f.s3.DownloadCompressedObject(ctx, bucket, key)
 	if err != nil {
 		return err
 	}
+	if !found {
+		return fn(nil)
+	}
 
-	return nil
+	defer r.Close()
+	return fn(r)
+}
+
+// spoolMetadata calls fn with the decompressed contents of a metadata object like streamMetadata,
+// keeping a copy of the compressed object in a temporary file. It returns the copy, or nil if the object does not exist.
+// The copy must be decompressed to be read again, and must be closed and removed by the caller.
+func (f *LambdaFunction) spoolMetadata(ctx context.Context, bucket, key string, fn func(r io.Reader) error) (*os.File, error) {
+	found, r, err := f.s3.DownloadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fn(nil)
+	}
+
+	defer r.Close()
+	return spoolCompressed(r, path.Base(key), fn)
+}
+
+// spoolCompressed calls fn with the decompressed contents of the gzip stream r,
+// copying the compressed stream into a temporary file which is returned rewound to its start.
+func spoolCompressed(r io.Reader, name string, fn func(r io.Reader) error) (*os.File, error) {
+	spool, err := ioutil.TempFile("", name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = func() error {
+		tee := io.TeeReader(r, spool)
+		g, err := gzip.NewReader(tee)
+		if err != nil {
+			return err
+		}
+		err = fn(g)
+		if err != nil {
+			return err
+		}
+		// fn may stop reading before the end of the document
+		_, err = io.Copy(ioutil.Discard, tee)
+		if err != nil {
+			return err
+		}
+		_, err = spool.Seek(0, io.SeekStart)
+		return err
+	}()
+	if err != nil {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+		return nil, err
+	}
+	return spool, nil
+}
+
+// MergeRepository updates the repository in bucket with packages by streaming its existing metadata
+// from S3 straight into the new metadata, so that memory use stays flat regardless of the size of the repository.
+func (f *LambdaFunction) MergeRepository(ctx context.Context, bucket string, packages []*yum.RPMObject) error {
+	merge := yum.NewMerge(packages...)
+
+	// primary is read twice, so it is downloaded once to a compressed temporary file while it is scanned
+	spool, err := f.spoolMetadata(ctx, bucket, storage.PrimaryXML, merge.ScanPrimary)
+	if err != nil {
+		return err
+	}
+	if spool != nil {
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+	}
+
+	if !merge.Updated() {
+		return nil
+	}
+
+	metadata, err := f.GetMetadata(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	primary, err := f.s3.UploadCompressedXMLStream(ctx, bucket, storage.PrimaryXML, func(e *xml.Encoder) error {
+		if spool == nil {
+			return merge.MergePrimary(nil, e)
+		}
+		g, err := gzip.NewReader(spool)
+		if err != nil {
+			return err
+		}
+		defer g.Close()
+		return merge.MergePrimary(g, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	filelist, err := f.s3.UploadCompressedXMLStream(ctx, bucket, storage.FilelistXML, func(e *xml.Encoder) error {
+		return f.streamMetadata(ctx, bucket, storage.FilelistXML, func(r io.Reader) error {
+			return merge.MergeFilelists(r, e)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.PutMetadata(ctx, bucket, metadata, merge.BuildTime(), primary, filelist)
+}
+
+// cachedRPM returns the cached scan of an RPM object, if the object has not changed since it was last scanned.
+func (f *LambdaFunction) cachedRPM(ctx context.Context, r events.Event) (*yum.RPM, bool) {
+	version := &storage.ObjectVersion{
+		Bucket:    r.Bucket.Name,
+		Key:       r.Object.Key,
+		ETag:      r.Object.ETag,
+		VersionID: r.Object.VersionID,
+	}
+
+	var err error
+	if version.ID() == "" {
+		version, err = f.s3.StatObject(ctx, r.Bucket.Name, r.Object.Key)
+		if err != nil {
+			f.l.Log(fmt.Sprintf("scan cache: %s", err))
+			return nil, false
+		}
+	}
+
+	rpm, found, err := f.cache.Get(ctx, *version)
+	if err != nil {
+		f.l.Log(fmt.Sprintf("scan cache: %s", err))
+		return nil, false
+	}
+	return rpm, found
 }
 
 func (f *LambdaFunction) LoadRPM(ctx context.Context, r events.Event) (*yum.RPMObject, error) {
+	if f.cache != nil {
+		rpm, found := f.cachedRPM(ctx, r)
+		if found {
+			return f.rpmObject(r.Object.Key, rpm), nil
+		}
+	}
+
 	o, err := s3.New(f.s3).GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: &r.Bucket.Name,
 		Key:    &r.Object.Key,
@@ -98,16 +389,52 @@ func (f *LambdaFunction) LoadRPM(ctx context.Context, r events.Event) (*yum.RPMO
 		return nil, errors.Wrap(err, "failed downloading RPM object")
 	}
 
-	rpm, err := yum.ScanRPM(ctx, o.Body)
+	var next int64 = scanProgressInterval
+	rpm, err := yum.ScanRPM(ctx, o.Body, func(n int64) {
+		if n >= next {
+			f.l.Log(fmt.Sprintf("scanning %q: %d bytes hashed", r.Object.Key, n))
+			next += scanProgressInterval
+		}
+	})
 	_ = o.Body.Close()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to scan RPM")
 	}
+	rpm.FileTime = aws.TimeValue(o.LastModified).Unix()
 
-	return &yum.RPMObject{
+	if f.cache != nil {
+		// key the cache by the version that was actually scanned
+		err = f.cache.Put(ctx, storage.ObjectVersion{
+			Bucket:    r.Bucket.Name,
+			Key:       r.Object.Key,
+			ETag:      aws.StringValue(o.ETag),
+			VersionID: aws.StringValue(o.VersionId),
+		}, rpm)
+		if err != nil {
+			f.l.Log(fmt.Sprintf("scan cache: %s", err))
+		}
+	}
+
+	return f.rpmObject(r.Object.Key, rpm), nil
+}
+
+// FileTime returns the file time of a scanned RPM whose FileTime is the last modified time of its object.
+// The last modified time is only used for metadata timestamped now, otherwise the file time is the timestamp
+// of the metadata, so that the same packages produce the same metadata whenever they were uploaded.
+func (f *LambdaFunction) FileTime(rpm *yum.RPM) int64 {
+	if f.timestamp == TimestampNow {
+		return rpm.FileTime
+	}
+	return f.Timestamp(rpm.BuildTime)
+}
+
+func (f *LambdaFunction) rpmObject(key string, rpm *yum.RPM) *yum.RPMObject {
+	o := &yum.RPMObject{
 		RPM: *rpm,
-		Key: r.Object.Key,
-	}, err
+		Key: key,
+	}
+	o.FileTime = f.FileTime(rpm)
+	return o
 }
 
 func (f *LambdaFunction) HandleBucketRequest(ctx context.Context, bucket string, events []events.Event) error {
@@ -128,6 +455,10 @@ func (f *LambdaFunction) HandleBucketRequest(ctx context.Context, bucket string,
 		return nil
 	}
 
+	if f.streaming {
+		return f.MergeRepository(ctx, bucket, packages)
+	}
+
 	repository, err := f.GetRepository(ctx, bucket)
 	if err != nil {
 		return err
@@ -169,11 +500,60 @@ func main() {
 			return err
 		}
 
+		store, err := setup.NewS3(s)
+		if err != nil {
+			return err
+		}
+
 		f := LambdaFunction{
-			l: setup.NewLog("lambda:create-repo-metadata"),
-			s3: &storage.S3{
-				Session: s,
-			},
+			l:  setup.NewLog("lambda:create-repo-metadata"),
+			s3: store,
+		}
+
+		f.streaming, err = strconv.ParseBool(setup.GetEnv(EnvStreamingMerge, "false"))
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvStreamingMerge)
+		}
+
+		// SOURCE_DATE_EPOCH is used as a fixed timestamp unless a timestamp is explicitly configured
+		f.timestamp = setup.GetEnv(EnvMetadataTimestamp, setup.GetEnv(EnvSourceDateEpoch, TimestampNow))
+		if f.timestamp != TimestampNow && f.timestamp != TimestampBuild {
+			_, err = strconv.ParseInt(f.timestamp, 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", EnvMetadataTimestamp)
+			}
+		}
+
+		f.revision = setup.GetEnv(EnvMetadataRevision, RevisionTimestamp)
+		if f.revision != RevisionTimestamp && f.revision != RevisionContent {
+			return errors.Errorf("invalid %s %q", EnvMetadataRevision, f.revision)
+		}
+
+		err = json.Unmarshal([]byte(setup.GetEnv(EnvRepositoryTags, "{}")), &f.tags)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvRepositoryTags)
+		}
+
+		// repository metadata is signed in-process when a signing key is configured
+		if setup.SigningKeyConfigured() {
+			f.secrets, err = setup.NewKeyProvider(s)
+			if err != nil {
+				return err
+			}
+			f.formats, err = secrets.ParseSignatureFormats(setup.GetEnv(EnvSignFormats, secrets.FormatArmored.Name), ",")
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", EnvSignFormats)
+			}
+		}
+
+		// an empty cache prefix disables the scan cache
+		prefix := setup.GetEnv(EnvScanCachePrefix, ".scan-cache")
+		if prefix != "" {
+			f.cache = &storage.ScanCache{
+				S3:     f.s3,
+				Bucket: setup.GetEnv(EnvScanCacheBucket, ""),
+				Prefix: prefix,
+			}
 		}
 
 		lambda.Start((&f).HandleRequest)
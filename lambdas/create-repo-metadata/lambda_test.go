@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"git.illumina.com/relvacode/rpm-lambda/yum"
+	"github.com/rustylynch/go-rpmutils"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileTime(t *testing.T) {
+	rpm := &yum.RPM{
+		Release:   &rpmutils.NEVRA{Name: "a", Version: "1.0", Release: "1", Arch: "x86_64"},
+		BuildTime: 1500000000,
+		FileTime:  1600000000,
+	}
+	for _, c := range []struct {
+		timestamp string
+		want      int64
+	}{
+		{TimestampNow, 1600000000},
+		{TimestampBuild, 1500000000},
+		{"1700000000", 1700000000},
+	} {
+		f := &LambdaFunction{timestamp: c.timestamp}
+		if got := f.FileTime(rpm); got != c.want {
+			t.Errorf("%s: file time %d, want %d", c.timestamp, got, c.want)
+		}
+		if o := f.rpmObject("a.rpm", rpm); o.Package().Time.File != c.want || rpm.FileTime != 1600000000 {
+			t.Errorf("%s: package file time %d", c.timestamp, o.Package().Time.File)
+		}
+	}
+}
+
+func TestSpoolCompressed(t *testing.T) {
+	content := bytes.Repeat([]byte("<package/>"), 1000)
+	var b bytes.Buffer
+	g := gzip.NewWriter(&b)
+	_, _ = g.Write(content)
+	_ = g.Close()
+	compressed := b.Bytes()
+
+	// fn reads only the start of the document
+	var scanned []byte
+	spool, err := spoolCompressed(bytes.NewReader(compressed), "primary.xml.gz", func(r io.Reader) error {
+		scanned = make([]byte, 10)
+		_, err := io.ReadFull(r, scanned)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if string(scanned) != "<package/>" {
+		t.Fatalf("scanned %q", scanned)
+	}
+	spooled, err := ioutil.ReadAll(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spooled, compressed) {
+		t.Fatalf("spool holds %d bytes starting % x, want the %d compressed bytes", len(spooled), spooled[:2], len(compressed))
+	}
+
+	_, _ = spool.Seek(0, io.SeekStart)
+	r, err := gzip.NewReader(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, content) {
+		t.Fatal("spool does not decompress to the original content")
+	}
+}
+
+func TestSpoolCompressedError(t *testing.T) {
+	spool, err := spoolCompressed(bytes.NewReader([]byte("<metadata/>")), "primary.xml.gz", func(r io.Reader) error {
+		t.Fatal("fn called with an uncompressed object")
+		return nil
+	})
+	if err == nil {
+		_ = os.Remove(spool.Name())
+		t.Fatal("expected an error spooling an uncompressed object")
+	}
+}
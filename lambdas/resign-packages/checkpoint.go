@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"git.illumina.com/relvacode/rpm-lambda/storage"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"path"
+)
+
+// Checkpoint is the progress of re-signing the RPMs under a prefix of a bucket.
+type Checkpoint struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// KeyID of the key RPMs are re-signed with, a checkpoint of another key is not resumed
+	KeyID string `json:"key_id"`
+	// StartAfter is the last key that was processed
+	StartAfter string `json:"start_after"`
+	Resigned   int    `json:"resigned"`
+	Skipped    int    `json:"skipped"`
+	// Failed are the keys of RPMs that could not be re-signed
+	Failed []string `json:"failed,omitempty"`
+	Done   bool     `json:"done"`
+}
+
+// checkpointLocation returns the bucket and key of the checkpoint of a job.
+func (f *LambdaFunction) checkpointLocation(bucket, prefix string) (string, string) {
+	cpBucket := f.checkpoints.Bucket
+	if cpBucket == "" {
+		cpBucket = bucket
+	}
+	return cpBucket, path.Join(f.checkpoints.Prefix, bucket, prefix, "checkpoint.json")
+}
+
+// LoadCheckpoint returns the checkpoint of a job, or a new checkpoint if the job has not been started with key ID.
+func (f *LambdaFunction) LoadCheckpoint(ctx context.Context, bucket, prefix, keyID string) (*Checkpoint, error) {
+	cp := &Checkpoint{Bucket: bucket, Prefix: prefix, KeyID: keyID}
+
+	cpBucket, cpKey := f.checkpointLocation(bucket, prefix)
+	found, r, err := f.s3.DownloadObject(ctx, cpBucket, cpKey)
+	if err != nil || !found {
+		return cp, err
+	}
+
+	defer r.Close()
+
+	var existing Checkpoint
+	err = json.NewDecoder(r).Decode(&existing)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode checkpoint")
+	}
+	if existing.Bucket != bucket || existing.Prefix != prefix || existing.KeyID != keyID {
+		return cp, nil
+	}
+	return &existing, nil
+}
+
+// SaveCheckpoint stores the checkpoint of a job.
+// Checkpoints are private whatever the ACL of the storage, so that they are not published with the repository.
+func (f *LambdaFunction) SaveCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(cp)
+	if err != nil {
+		return err
+	}
+
+	bucket, key := f.checkpointLocation(cp.Bucket, cp.Prefix)
+	err = f.s3.UploadObjectWithOptions(ctx, &b, bucket, key, "application/json", storage.UploadOptions{ACL: s3.ObjectCannedACLPrivate})
+	return errors.Wrap(err, "save checkpoint")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"git.illumina.com/relvacode/rpm-lambda/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 stores objects by their path-style URL path, "/<bucket>/<key>".
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// acls are the canned ACLs objects were uploaded with
+	acls map[string]string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = b
+		s.acls[r.URL.Path] = r.Header.Get("X-Amz-Acl")
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet:
+		b, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		_, _ = w.Write(b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestFunction(t *testing.T, checkpoints Checkpoints) (*LambdaFunction, *fakeS3, func()) {
+	fake := &fakeS3{objects: make(map[string][]byte), acls: make(map[string]string)}
+	server := httptest.NewServer(fake)
+
+	s, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	f := &LambdaFunction{
+		s3:          &storage.S3{Session: s, Options: storage.UploadOptions{ACL: "public-read"}},
+		checkpoints: checkpoints,
+	}
+	return f, fake, server.Close
+}
+
+func TestCheckpointResume(t *testing.T) {
+	f, fake, done := newTestFunction(t, Checkpoints{Prefix: ".resign-checkpoints"})
+	defer done()
+	ctx := context.Background()
+
+	cp, err := f.LoadCheckpoint(ctx, "repo", "el7", "AF8C37080C7877B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Checkpoint{Bucket: "repo", Prefix: "el7", KeyID: "AF8C37080C7877B2"}
+	if !reflect.DeepEqual(cp, want) {
+		t.Fatalf("new job: got %+v, want %+v", cp, want)
+	}
+
+	cp.StartAfter = "el7/b-1.0-1.x86_64.rpm"
+	cp.Resigned = 2
+	cp.Skipped = 1
+	cp.Failed = []string{"el7/a-1.0-1.x86_64.rpm"}
+	err = f.SaveCheckpoint(ctx, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/repo/.resign-checkpoints/repo/el7/checkpoint.json"]; !ok {
+		t.Fatalf("checkpoint not stored in the job bucket: %v", fake.objects)
+	}
+	if acl := fake.acls["/repo/.resign-checkpoints/repo/el7/checkpoint.json"]; acl != "private" {
+		t.Errorf("checkpoint uploaded with ACL %q, want private", acl)
+	}
+
+	resumed, err := f.LoadCheckpoint(ctx, "repo", "el7", "AF8C37080C7877B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed, cp) {
+		t.Errorf("resumed: got %+v, want %+v", resumed, cp)
+	}
+
+	// another prefix of the same bucket is another job
+	other, err := f.LoadCheckpoint(ctx, "repo", "el8", "AF8C37080C7877B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.StartAfter != "" || other.Resigned != 0 {
+		t.Errorf("job of another prefix resumed from %+v", other)
+	}
+}
+
+func TestCheckpointRestartsWithNewKey(t *testing.T) {
+	f, _, done := newTestFunction(t, Checkpoints{})
+	defer done()
+	ctx := context.Background()
+
+	err := f.SaveCheckpoint(ctx, &Checkpoint{Bucket: "repo", Prefix: "el7", KeyID: "AF8C37080C7877B2", StartAfter: "el7/b.rpm", Done: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key was rotated again since the checkpoint was saved, every RPM must be checked again
+	cp, err := f.LoadCheckpoint(ctx, "repo", "el7", "D8FD397B3AC87976")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Checkpoint{Bucket: "repo", Prefix: "el7", KeyID: "D8FD397B3AC87976"}
+	if !reflect.DeepEqual(cp, want) {
+		t.Errorf("got %+v, want %+v", cp, want)
+	}
+}
+
+func TestCheckpointBucket(t *testing.T) {
+	f, fake, done := newTestFunction(t, Checkpoints{Bucket: "state", Prefix: "resign"})
+	defer done()
+	ctx := context.Background()
+
+	cp := &Checkpoint{Bucket: "repo", Prefix: "el7", KeyID: "AF8C37080C7877B2", StartAfter: "el7/b.rpm"}
+	err := f.SaveCheckpoint(ctx, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/state/resign/repo/el7/checkpoint.json"]; !ok {
+		t.Fatalf("checkpoint not stored in the checkpoint bucket: %v", fake.objects)
+	}
+
+	resumed, err := f.LoadCheckpoint(ctx, "repo", "el7", "AF8C37080C7877B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed, cp) {
+		t.Errorf("resumed: got %+v, want %+v", resumed, cp)
+	}
+}
+
+func TestCheckpointInvalid(t *testing.T) {
+	f, fake, done := newTestFunction(t, Checkpoints{})
+	defer done()
+
+	fake.objects["/repo/repo/el7/checkpoint.json"] = []byte("{")
+	_, err := f.LoadCheckpoint(context.Background(), "repo", "el7", "AF8C37080C7877B2")
+	if err == nil || !strings.Contains(err.Error(), "decode checkpoint") {
+		t.Errorf("corrupt checkpoint: %v, want a decode error", err)
+	}
+}
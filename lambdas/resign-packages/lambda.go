@@ -0,0 +1,276 @@
+// Lambda - Re-sign Packages
+// Walks the RPMs under a prefix of a bucket and re-signs every RPM that is not signed by the current signing key,
+// for use after the signing key has been rotated.
+package main // import "git.illumina.com/relvacode/rpm-lambda/lambdas/resign-packages"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"git.illumina.com/relvacode/rpm-lambda/events"
+	"git.illumina.com/relvacode/rpm-lambda/secrets"
+	"git.illumina.com/relvacode/rpm-lambda/setup"
+	"git.illumina.com/relvacode/rpm-lambda/signing"
+	"git.illumina.com/relvacode/rpm-lambda/storage"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	EnvMetadataQueueURL = `LAMBDA_METADATA_QUEUE_URL`
+	EnvCheckpointBucket = `LAMBDA_CHECKPOINT_BUCKET`
+	EnvCheckpointPrefix = `LAMBDA_CHECKPOINT_PREFIX`
+	EnvTimeoutMargin    = `LAMBDA_TIMEOUT_MARGIN`
+)
+
+// Request starts or resumes re-signing the RPMs under Prefix in Bucket.
+type Request struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// Restart discards the checkpoint of a previous run
+	Restart bool `json:"restart"`
+}
+
+// Checkpoints is the location checkpoints are stored under.
+type Checkpoints struct {
+	// Bucket is the checkpoint bucket, or the bucket being re-signed if empty
+	Bucket string
+	Prefix string
+}
+
+type LambdaFunction struct {
+	l       aws.Logger
+	s3      *storage.S3
+	sqs     *sqs.SQS
+	secrets secrets.GPGProvider
+	options signing.Options
+
+	// queue is the URL of the queue of create-repo-metadata, notified of every re-signed RPM if set
+	queue       string
+	checkpoints Checkpoints
+	// margin is the time left before the lambda times out at which no further RPMs are started
+	margin time.Duration
+}
+
+// Resign re-signs the RPM at key in bucket with the signing key and writes it back in place.
+// RPMs that are already signed by the signing key are left as they are.
+// Returns true if the RPM was re-signed.
+func (f *LambdaFunction) Resign(ctx context.Context, key *openpgp.Entity, bucket, objectKey string) (bool, error) {
+	// the RPM is read more than once, each read must see the same version of the object
+	version, err := f.s3.StatObject(ctx, bucket, objectKey)
+	if err != nil {
+		return false, err
+	}
+	open := func(offset int64) (io.ReadCloser, error) {
+		return f.s3.DownloadObjectVersion(ctx, *version, offset)
+	}
+
+	r, err := open(0)
+	if err != nil {
+		return false, err
+	}
+	// only the headers are read
+	sigs, err := signing.ReadSignatures(r)
+	_ = r.Close()
+	if err != nil {
+		return false, err
+	}
+	if sigs.SignedBy(key.PrivateKey.KeyId) {
+		r, err = open(0)
+		if err != nil {
+			return false, err
+		}
+		err = signing.Verify(r, key)
+		_ = r.Close()
+		if err == nil {
+			return false, nil
+		}
+		f.l.Log(fmt.Sprintf("s3://%s/%s: signature by the signing key does not verify: %s", bucket, objectKey, err))
+	}
+
+	// the re-signed RPM keeps the user metadata and tags of the original
+	meta, err := f.s3.ObjectMetadata(ctx, *version)
+	if err != nil {
+		return false, err
+	}
+
+	// the signed header is verified before anything is uploaded
+	body, err := signing.SignStream(key.PrivateKey, open, f.options)
+	if err != nil {
+		return false, err
+	}
+
+	defer body.Close()
+
+	// An RPM published while it was being re-signed is newer than the one that was signed, leave it to sign-package.
+	// This check is best-effort: S3 has no conditional writes, so an RPM published between the check and the upload
+	// below is still overwritten by the re-signed original.
+	current, err := f.s3.StatObject(ctx, bucket, objectKey)
+	if err != nil {
+		return false, err
+	}
+	if current.ID() != version.ID() {
+		f.l.Log(fmt.Sprintf("s3://%s/%s was replaced while it was re-signed", bucket, objectKey))
+		return false, nil
+	}
+
+	// the object is replaced by a single upload, readers see either the original or the re-signed RPM.
+	// The original may have been uploaded with an immutable Cache-Control, which no longer holds once it is replaced.
+	meta.CacheControl = f.s3.Cache.MutableCacheControl()
+	resigned, err := f.s3.UploadObjectVersion(ctx, body, bucket, objectKey, "application/x-rpm", meta)
+	if err != nil {
+		return false, err
+	}
+
+	f.l.Log(fmt.Sprintf("re-signed s3://%s/%s, previously signed by %s", bucket, objectKey, sigs))
+	return true, f.NotifyMetadata(ctx, *resigned)
+}
+
+// NotifyMetadata sends an object created event for a re-signed RPM to the queue of create-repo-metadata,
+// so that the checksum of the RPM is updated in the repository metadata.
+func (f *LambdaFunction) NotifyMetadata(ctx context.Context, v storage.ObjectVersion) error {
+	if f.queue == "" {
+		return nil
+	}
+
+	var event events.LambdaS3CreateObjectEvent
+	event.Records = make([]struct {
+		S3 events.Event `json:"s3"`
+	}, 1)
+	event.Records[0].S3.Bucket.Name = v.Bucket
+	event.Records[0].S3.Object.Key = v.Key
+	// S3 events carry the ETag without quotes
+	event.Records[0].S3.Object.ETag = strings.Trim(v.ETag, `"`)
+	event.Records[0].S3.Object.VersionID = v.VersionID
+
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(&event)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(f.queue),
+		MessageBody: aws.String(b.String()),
+	})
+	return errors.Wrap(err, "notify metadata queue")
+}
+
+// HandleRequest re-signs RPMs in key order from the checkpoint of the job until every RPM has been processed,
+// or until the lambda is about to time out. The returned checkpoint is Done once every RPM has been processed,
+// otherwise the lambda should be invoked again with the same request to resume.
+func (f *LambdaFunction) HandleRequest(ctx context.Context, req Request) (*Checkpoint, error) {
+	if req.Bucket == "" {
+		return nil, errors.New("no bucket in request")
+	}
+
+	key, err := f.secrets.LoadPrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{Bucket: req.Bucket, Prefix: req.Prefix, KeyID: signing.KeyID(key.PrivateKey.KeyId)}
+	if !req.Restart {
+		cp, err = f.LoadCheckpoint(ctx, req.Bucket, req.Prefix, cp.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		if cp.Done {
+			return cp, nil
+		}
+	}
+
+	var stopped bool
+	err = f.s3.WalkObjects(ctx, req.Bucket, req.Prefix, cp.StartAfter, func(objectKey string) (bool, error) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < f.margin {
+			stopped = true
+			return false, nil
+		}
+		if !strings.HasSuffix(objectKey, ".rpm") {
+			cp.StartAfter = objectKey
+			return true, nil
+		}
+
+		resigned, err := f.Resign(ctx, key, req.Bucket, objectKey)
+		switch {
+		case ctx.Err() != nil:
+			return false, ctx.Err()
+		case err != nil:
+			f.l.Log(fmt.Sprintf("failed to re-sign s3://%s/%s: %s", req.Bucket, objectKey, err))
+			cp.Failed = append(cp.Failed, objectKey)
+		case resigned:
+			cp.Resigned++
+		default:
+			cp.Skipped++
+		}
+
+		cp.StartAfter = objectKey
+		if err != nil || resigned {
+			return true, f.SaveCheckpoint(ctx, cp)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cp.Done = !stopped
+	f.l.Log(fmt.Sprintf("s3://%s/%s: %d re-signed, %d already signed, %d failed, done: %t",
+		req.Bucket, req.Prefix, cp.Resigned, cp.Skipped, len(cp.Failed), cp.Done))
+	return cp, f.SaveCheckpoint(ctx, cp)
+}
+
+func main() {
+	setup.Main(func() error {
+		s, err := setup.NewSession()
+		if err != nil {
+			return err
+		}
+
+		store, err := setup.NewS3(s)
+		if err != nil {
+			return err
+		}
+
+		f := LambdaFunction{
+			l:     setup.NewLog("lambda:resign-packages"),
+			s3:    store,
+			sqs:   sqs.New(s),
+			queue: setup.GetEnv(EnvMetadataQueueURL, ""),
+			checkpoints: Checkpoints{
+				Bucket: setup.GetEnv(EnvCheckpointBucket, ""),
+				Prefix: strings.Trim(setup.GetEnv(EnvCheckpointPrefix, ".resign-checkpoints"), "/"),
+			},
+		}
+
+		if f.checkpoints.Prefix != "" {
+			// checkpoints stored in the repository bucket must not be given the policy of repository objects
+			store.Cache.Private = append(store.Cache.Private, f.checkpoints.Prefix)
+		}
+
+		f.secrets, err = setup.NewKeyProvider(s)
+		if err != nil {
+			return err
+		}
+
+		f.options, err = setup.NewSigningOptions()
+		if err != nil {
+			return err
+		}
+
+		f.margin, err = time.ParseDuration(setup.GetEnv(EnvTimeoutMargin, "1m"))
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvTimeoutMargin)
+		}
+
+		lambda.Start((&f).HandleRequest)
+		return nil
+	})
+}
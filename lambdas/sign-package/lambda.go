@@ -5,99 +5,211 @@
 package main // import "git.illumina.com/relvacode/rpm-lambda/lambdas/sign-package"
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"git.illumina.com/relvacode/rpm-lambda/events"
 	"git.illumina.com/relvacode/rpm-lambda/secrets"
 	"git.illumina.com/relvacode/rpm-lambda/setup"
+	"git.illumina.com/relvacode/rpm-lambda/signing"
 	"git.illumina.com/relvacode/rpm-lambda/storage"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/rustylynch/go-rpmutils"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/openpgp"
-	"golang.org/x/sync/errgroup"
 	"io"
-	"io/ioutil"
-	"os"
+	"net/url"
+	"path"
 	"strings"
 )
 
 const (
-	EnvS3TargetBucket             = `LAMBDA_S3_TARGET`
-	EnvSigningKeySecret           = `LAMBDA_SECRET_GPG_KEY`
-	EnvSigningKeyPassphraseSecret = `LAMBDA_SECRET_GPG_PASSPHRASE`
+	EnvS3TargetBucket = `LAMBDA_S3_TARGET`
+	EnvRoutes         = `LAMBDA_ROUTES`
+	EnvRoutesObject   = `LAMBDA_ROUTES_OBJECT`
+
+	EnvSignedPolicy = `LAMBDA_SIGNED_POLICY`
+
+	EnvOriginalAction      = `LAMBDA_ORIGINAL_ACTION`
+	EnvArchiveBucket       = `LAMBDA_ARCHIVE_BUCKET`
+	EnvArchivePrefix       = `LAMBDA_ARCHIVE_PREFIX`
+	EnvArchiveStorageClass = `LAMBDA_ARCHIVE_STORAGE_CLASS`
+	EnvArchiveTags         = `LAMBDA_ARCHIVE_TAGS`
+)
+
+// Actions taken on the un-signed original RPM once the signed RPM has been uploaded
+const (
+	OriginalDelete  = "delete"
+	OriginalArchive = "archive"
+	OriginalKeep    = "keep"
 )
 
+// Policies for RPMs that are already signed by a key other than the signing key
+const (
+	// PolicyReplace replaces the existing signature
+	PolicyReplace = "replace"
+	// PolicyReject leaves the RPM where it is
+	PolicyReject = "reject"
+	// PolicyKeep publishes the RPM with its existing signature
+	PolicyKeep = "keep"
+)
+
+// Archive is the location un-signed originals are moved to.
+type Archive struct {
+	// Bucket is the archive bucket, or the bucket of the original if empty
+	Bucket  string
+	Prefix  string
+	Options storage.CopyOptions
+}
+
+// Location returns the bucket and key an original object is archived to.
+func (a *Archive) Location(bucket, key string) (string, string) {
+	if a.Bucket != "" {
+		bucket = a.Bucket
+	}
+	return bucket, path.Join(a.Prefix, key)
+}
+
+// Contains returns true if the object is an archived original.
+func (a *Archive) Contains(bucket, key string) bool {
+	return (a.Bucket == "" || a.Bucket == bucket) && strings.HasPrefix(key, a.Prefix+"/")
+}
+
 type LambdaFunction struct {
-	l       aws.Logger
-	s3      *storage.S3
-	secrets secrets.GPGProvider
-	target  string
+	l        aws.Logger
+	s3       *storage.S3
+	secrets  secrets.GPGProvider
+	routes   Routes
+	policy   string
+	options  signing.Options
+	original string
+	archive  Archive
+}
+
+// Inspect decides from the existing signatures of an RPM whether it should be signed, and whether it should be published at all.
+// RPMs that are already signed by key are published as they are, once their signature has been verified.
+func (f *LambdaFunction) Inspect(key *openpgp.Entity, name string, sigs signing.Signatures, open signing.Opener) (sign bool, publish bool, err error) {
+	if len(sigs) == 0 {
+		return true, true, nil
+	}
+
+	f.l.Log(fmt.Sprintf("%s is signed by %s", name, sigs))
+
+	if sigs.SignedBy(key.PrivateKey.KeyId) {
+		r, err := open(0)
+		if err != nil {
+			return false, false, err
+		}
+		err = signing.Verify(r, key)
+		_ = r.Close()
+		if err == nil {
+			f.l.Log(fmt.Sprintf("%s is already signed by the signing key", name))
+			return false, true, nil
+		}
+		f.l.Log(fmt.Sprintf("%s: signature by the signing key does not verify: %s", name, err))
+	}
+
+	switch f.policy {
+	case PolicyReject:
+		f.l.Log(fmt.Sprintf("rejected %s: signed by another key", name))
+		return false, false, nil
+	case PolicyKeep:
+		return false, true, nil
+	}
+	f.l.Log(fmt.Sprintf("replacing signature of %s", name))
+	return true, true, nil
+}
+
+// HandleOriginal deletes, archives or keeps the un-signed original of a signed RPM.
+func (f *LambdaFunction) HandleOriginal(ctx context.Context, event events.Event) error {
+	switch f.original {
+	case OriginalKeep:
+		return nil
+	case OriginalArchive:
+		bucket, key := f.archive.Location(event.Bucket.Name, event.Object.Key)
+		err := f.s3.CopyObject(ctx, event.Bucket.Name, event.Object.Key, bucket, key, f.archive.Options)
+		if err != nil {
+			return errors.Wrap(err, "archive original")
+		}
+		f.l.Log(fmt.Sprintf("archived original s3://%s/%s to s3://%s/%s", event.Bucket.Name, event.Object.Key, bucket, key))
+	}
+
+	return f.s3.DeleteObject(ctx, event.Bucket.Name, event.Object.Key)
 }
 
 func (f *LambdaFunction) HandleEvent(ctx context.Context, key *openpgp.Entity, event events.Event) error {
 	if !strings.HasSuffix(event.Object.Key, ".rpm") {
 		return nil
 	}
-
-	// Open a temporary file to write signed RPM contents
-	// (signing an RPM requires a read-seeker)
-	fd, err := ioutil.TempFile(os.TempDir(), "rpm-sign")
-	if err != nil {
-		return err
+	// archived originals must not be signed again
+	if f.original == OriginalArchive && f.archive.Contains(event.Bucket.Name, event.Object.Key) {
+		return nil
 	}
 
-	defer os.Remove(fd.Name())
-	defer fd.Close()
+	bucket, target, ok := f.routes.Target(event.Object.Key)
+	if !ok {
+		f.l.Log(fmt.Sprintf("rejected s3://%s/%s: no matching route", event.Bucket.Name, event.Object.Key))
+		return nil
+	}
 
-	_, r, err := f.s3.DownloadObject(ctx, event.Bucket.Name, event.Object.Key)
+	// the RPM may be read more than once, each read must see the same version of the object
+	version, err := f.s3.StatObject(ctx, event.Bucket.Name, event.Object.Key)
 	if err != nil {
 		return err
 	}
+	open := func(offset int64) (io.ReadCloser, error) {
+		return f.s3.DownloadObjectVersion(ctx, *version, offset)
+	}
 
-	// copy the contents of the un-signed RPM file to disk
-	_, err = io.Copy(fd, r)
+	r, err := open(0)
 	if err != nil {
 		return err
 	}
 
-	err = r.Close()
+	defer r.Close()
+
+	// the headers are buffered as their signatures are read, so that the RPM is signed or published from the same download
+	var head bytes.Buffer
+	sigs, err := signing.ReadSignatures(io.TeeReader(r, &head))
 	if err != nil {
 		return err
 	}
+	rpm := io.MultiReader(&head, r)
 
-	// seek to the beginning of the file
-	_, err = fd.Seek(0, 0)
+	sign, publish, err := f.Inspect(key, fmt.Sprintf("s3://%s/%s", event.Bucket.Name, event.Object.Key), sigs, open)
 	if err != nil {
 		return err
 	}
+	if !publish {
+		return nil
+	}
 
-	pr, pw := io.Pipe()
-
-	g, groupCtx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		err = rpmutils.SignRpmFileIntoStream(pw, fd, key.PrivateKey, nil)
-		_ = pw.CloseWithError(err)
-		return err
-	})
-
-	err = f.s3.UploadObject(groupCtx, pr, f.target, event.Object.Key, "application/x-rpm")
+	// the signed RPM keeps the user metadata and tags of the original
+	meta, err := f.s3.ObjectMetadata(ctx, *version)
 	if err != nil {
 		return err
 	}
-	_ = pr.Close()
 
-	err = g.Wait()
-	if err != nil {
-		return err
+	body := rpm
+	if sign {
+		// the signed header is verified before anything is uploaded,
+		// the payload is then streamed again from after the original signature header
+		signed, err := signing.SignReader(key.PrivateKey, rpm, open, f.options)
+		if err != nil {
+			return err
+		}
+
+		defer signed.Close()
+		body = signed
 	}
 
-	// finally, delete the original object
-	err = f.s3.DeleteObject(ctx, event.Bucket.Name, event.Object.Key)
+	err = f.s3.UploadObjectWithOptions(ctx, body, bucket, target, "application/x-rpm", meta)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// finally, clean up the original object
+	return f.HandleOriginal(ctx, event)
 }
 
 func (f *LambdaFunction) HandleRequest(ctx context.Context, topic *events.LambdaS3CreateObjectEvent) error {
@@ -123,16 +235,65 @@ func main() {
 			return err
 		}
 
+		store, err := setup.NewS3(s)
+		if err != nil {
+			return err
+		}
+
 		f := LambdaFunction{
-			target: setup.GetEnv(EnvS3TargetBucket),
-			l:      setup.NewLog("lambda:sign-repo"),
-			s3: &storage.S3{
-				Session: s,
-			},
-			secrets: secrets.NewAmazonKeyProvider(
-				setup.GetEnv(EnvSigningKeySecret),
-				setup.GetEnv(EnvSigningKeyPassphraseSecret, ""),
-				s),
+			l:  setup.NewLog("lambda:sign-repo"),
+			s3: store,
+		}
+
+		f.secrets, err = setup.NewKeyProvider(s)
+		if err != nil {
+			return err
+		}
+
+		// routes are loaded from an S3 object, the environment, or default to a single target bucket
+		switch {
+		case setup.GetEnv(EnvRoutesObject, "") != "":
+			f.routes, err = f.LoadRoutes(context.Background(), setup.GetEnv(EnvRoutesObject))
+		case setup.GetEnv(EnvRoutes, "") != "":
+			f.routes, err = ParseRoutes(strings.NewReader(setup.GetEnv(EnvRoutes)))
+		default:
+			f.routes = Routes{{Bucket: setup.GetEnv(EnvS3TargetBucket)}}
+		}
+		if err != nil {
+			return errors.Wrap(err, "load routes")
+		}
+
+		f.policy = setup.GetEnv(EnvSignedPolicy, PolicyReplace)
+		if f.policy != PolicyReplace && f.policy != PolicyReject && f.policy != PolicyKeep {
+			return errors.Errorf("invalid %s %q", EnvSignedPolicy, f.policy)
+		}
+
+		f.options, err = setup.NewSigningOptions()
+		if err != nil {
+			return err
+		}
+
+		f.original = setup.GetEnv(EnvOriginalAction, OriginalDelete)
+		switch f.original {
+		case OriginalDelete, OriginalKeep:
+		case OriginalArchive:
+			f.archive = Archive{
+				Bucket: setup.GetEnv(EnvArchiveBucket, ""),
+				Prefix: strings.Trim(setup.GetEnv(EnvArchivePrefix, "archive"), "/"),
+				Options: storage.CopyOptions{
+					StorageClass: setup.GetEnv(EnvArchiveStorageClass, ""),
+					Tagging:      setup.GetEnv(EnvArchiveTags, ""),
+				},
+			}
+			if f.archive.Prefix == "" {
+				return errors.Errorf("%s must not be empty", EnvArchivePrefix)
+			}
+			_, err = url.ParseQuery(f.archive.Options.Tagging)
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", EnvArchiveTags)
+			}
+		default:
+			return errors.Errorf("invalid %s %q", EnvOriginalAction, f.original)
 		}
 
 		lambda.Start((&f).HandleRequest)
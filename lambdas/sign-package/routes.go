@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Route maps incoming RPM keys to the bucket and prefix that the signed RPM is uploaded to.
+type Route struct {
+	// Match is a key prefix ending in "/", or a glob pattern as understood by path.Match if it contains any of `*?[\`.
+	// The matched prefix is replaced by the target prefix, keys matched by a pattern are kept in full.
+	// An empty Match matches every key.
+	Match  string `json:"match"`
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+}
+
+func (r Route) isPattern() bool {
+	return strings.ContainsAny(r.Match, `*?[\`)
+}
+
+// Target returns the bucket and key that a signed RPM is uploaded to, or false if the key does not match the route.
+func (r Route) Target(key string) (string, string, bool) {
+	if r.isPattern() {
+		if ok, _ := path.Match(r.Match, key); !ok {
+			return "", "", false
+		}
+		return r.Bucket, r.join(key), true
+	}
+
+	if !strings.HasPrefix(key, r.Match) {
+		return "", "", false
+	}
+	return r.Bucket, r.join(strings.TrimPrefix(key, r.Match)), true
+}
+
+// join returns key under the target prefix. Keys are not cleaned, so that the signed RPM keeps the name it was uploaded with.
+func (r Route) join(key string) string {
+	if r.Prefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(r.Prefix, "/") + "/" + key
+}
+
+// Routes is an ordered routing table, the first matching route is used.
+type Routes []Route
+
+// Target returns the target of the first route matching key.
+func (routes Routes) Target(key string) (string, string, bool) {
+	for _, r := range routes {
+		bucket, target, ok := r.Target(key)
+		if ok {
+			return bucket, target, true
+		}
+	}
+	return "", "", false
+}
+
+func (routes Routes) validate() error {
+	for i, r := range routes {
+		if r.Bucket == "" {
+			return errors.Errorf("route %d: no target bucket", i)
+		}
+		if r.isPattern() {
+			_, err := path.Match(r.Match, "")
+			if err != nil {
+				return errors.Wrapf(err, "route %d", i)
+			}
+		} else if r.Match != "" && !strings.HasSuffix(r.Match, "/") {
+			// "el7" would also match "el7-testing/"
+			return errors.Errorf("route %d: prefix %q does not end with /", i, r.Match)
+		}
+	}
+	return nil
+}
+
+// ParseRoutes parses a JSON array of routes.
+func ParseRoutes(r io.Reader) (Routes, error) {
+	var routes Routes
+	err := json.NewDecoder(r).Decode(&routes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse routes")
+	}
+	return routes, routes.validate()
+}
+
+// LoadRoutes downloads a routing table from an s3://bucket/key URI.
+func (f *LambdaFunction) LoadRoutes(ctx context.Context, uri string) (Routes, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, errors.Errorf("routes object %q is not an s3:// URI", uri)
+	}
+
+	found, r, err := f.s3.DownloadObject(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Errorf("routes object %q does not exist", uri)
+	}
+
+	defer r.Close()
+	return ParseRoutes(r)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoutesTarget(t *testing.T) {
+	routes := Routes{
+		{Match: "el7/", Bucket: "public-repo", Prefix: "product-a/el7"},
+		{Match: "*/private/*.rpm", Bucket: "private-repo"},
+		{Bucket: "default-repo"},
+	}
+
+	for _, c := range []struct {
+		key, bucket, target string
+	}{
+		{"el7/foo-1.0-1.x86_64.rpm", "public-repo", "product-a/el7/foo-1.0-1.x86_64.rpm"},
+		// a prefix only matches whole path segments
+		{"el7-testing/foo-1.0-1.x86_64.rpm", "default-repo", "el7-testing/foo-1.0-1.x86_64.rpm"},
+		{"el8/private/foo-1.0-1.x86_64.rpm", "private-repo", "el8/private/foo-1.0-1.x86_64.rpm"},
+		// keys are used as they are, path.Join would have cleaned them
+		{"el8//foo-1.0-1.x86_64.rpm", "default-repo", "el8//foo-1.0-1.x86_64.rpm"},
+		{"el7/./foo-1.0-1.x86_64.rpm", "public-repo", "product-a/el7/./foo-1.0-1.x86_64.rpm"},
+	} {
+		bucket, target, ok := routes.Target(c.key)
+		if !ok || bucket != c.bucket || target != c.target {
+			t.Errorf("%s: got s3://%s/%s (%t), want s3://%s/%s", c.key, bucket, target, ok, c.bucket, c.target)
+		}
+	}
+
+	if _, _, ok := routes[:2].Target("el8/foo-1.0-1.x86_64.rpm"); ok {
+		t.Error("key matching no route has a target")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(strings.NewReader(`[{"match": "el7/", "bucket": "public-repo", "prefix": "el7/"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, target, _ := routes.Target("el7/foo.rpm"); target != "el7/foo.rpm" {
+		t.Errorf("target prefix with a trailing /: got %q", target)
+	}
+
+	for _, s := range []string{
+		`[{"match": "el7", "bucket": "public-repo"}]`,
+		`[{"match": "el7/"}]`,
+		`[{"match": "[", "bucket": "public-repo"}]`,
+	} {
+		_, err = ParseRoutes(strings.NewReader(s))
+		if err == nil {
+			t.Errorf("%s: invalid routes parsed", s)
+		}
+	}
+}
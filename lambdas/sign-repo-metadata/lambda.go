@@ -1,6 +1,6 @@
 // Lambda - Sign Repo Metadata
 // Receives a create object request from S3 in the form of repository metadata files (.xml and .xml.gz)
-// then signs the contents of each file and uploads the signature (.asc) to the same bucket as the
+// then signs the contents of each file and uploads its signatures (.asc, .sig or clear-signed) to the same bucket as the
 // originating request
 package main // import "git.illumina.com/relvacode/rpm-lambda/lambdas/sign-repo"
 
@@ -14,50 +14,185 @@ import (
 	"git.illumina.com/relvacode/rpm-lambda/storage"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/openpgp"
+	"path"
+	"strings"
 )
 
 const (
-	EnvSigningKeySecret           = `LAMBDA_SECRET_GPG_KEY`
-	EnvSigningKeyPassphraseSecret = `LAMBDA_SECRET_GPG_PASSPHRASE`
+	EnvSignInclude = `LAMBDA_SIGN_INCLUDE`
+	EnvSignExclude = `LAMBDA_SIGN_EXCLUDE`
+	EnvSignFormats = `LAMBDA_SIGN_FORMATS`
 )
 
+// Target is a pattern of keys to sign and the formats of their signatures.
+// Patterns are matched against the whole key as understood by path.Match.
+type Target struct {
+	Pattern string
+	Formats []secrets.SignatureFormat
+}
+
+// Rules select the objects that are signed by their key.
+type Rules struct {
+	Include []Target
+	Exclude []string
+}
+
+func (r Rules) match(key string) []secrets.SignatureFormat {
+	for _, p := range r.Exclude {
+		if ok, _ := path.Match(p, key); ok {
+			return nil
+		}
+	}
+	for _, t := range r.Include {
+		if ok, _ := path.Match(t.Pattern, key); ok {
+			return t.Formats
+		}
+	}
+	return nil
+}
+
+// Match returns the formats of the signatures of an object, or nothing if it should not be signed.
+// Signatures are never signed so that the lambda cannot trigger itself.
+func (r Rules) Match(key string) []secrets.SignatureFormat {
+	if secrets.IsSignature(key) {
+		return nil
+	}
+	return r.match(key)
+}
+
+// ParsePatterns parses a comma separated list of patterns.
+func ParsePatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		_, err := path.Match(p, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// ParseTargets parses a comma separated list of targets.
+// Each target is a pattern, optionally followed by = and the names of its signature formats separated by +,
+// e.g. "repodata/repomd.xml=armored+binary". Targets without formats use the given default formats.
+func ParseTargets(s string, formats []secrets.SignatureFormat) ([]Target, error) {
+	var targets []Target
+	for _, p := range strings.Split(s, ",") {
+		t := Target{Formats: formats}
+
+		var err error
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) == 2 {
+			t.Formats, err = secrets.ParseSignatureFormats(parts[1], "+")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		patterns, err := ParsePatterns(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(patterns) == 0 {
+			continue
+		}
+		t.Pattern = patterns[0]
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
 type LambdaFunction struct {
 	l       aws.Logger
 	s3      *storage.S3
 	secrets secrets.GPGProvider
+	rules   Rules
 }
 
-func (f *LambdaFunction) HandleEvent(ctx context.Context, key *openpgp.Entity, event events.Event) error {
-	var b bytes.Buffer
-
-	_, r, err := f.s3.DownloadObject(ctx, event.Bucket.Name, event.Object.Key)
+// HandleEvent signs the object of an event with key in each of formats.
+func (f *LambdaFunction) HandleEvent(ctx context.Context, key *openpgp.Entity, event events.Event, formats []secrets.SignatureFormat) error {
+	version, err := f.s3.StatObject(ctx, event.Bucket.Name, event.Object.Key)
 	if err != nil {
 		return err
 	}
+	etag := strings.Trim(version.ETag, `"`)
 
-	err = secrets.DetachedSign(key, r, &b)
-	_ = r.Close()
+	// skip signatures that are already up to date
+	var pending []secrets.SignatureFormat
+	for _, format := range formats {
+		meta, found, err := f.s3.UserMetadata(ctx, event.Bucket.Name, format.Key(event.Object.Key))
+		if err != nil {
+			return err
+		}
+		if !found || meta[storage.MetadataSignedETag] != etag {
+			pending = append(pending, format)
+		}
+	}
+	if len(pending) == 0 {
+		f.l.Log(fmt.Sprintf("skipping s3://%s/%s: signatures are up to date", event.Bucket.Name, event.Object.Key))
+		return nil
+	}
+
+	r, err := f.s3.DownloadObjectVersion(ctx, *version, 0)
 	if err != nil {
 		return err
 	}
 
-	err = f.s3.UploadObject(ctx, &b, event.Bucket.Name, fmt.Sprintf("%s.asc", event.Object.Key), "application/octet-stream")
+	// the object is signed once for each format
+	var data bytes.Buffer
+	_, err = data.ReadFrom(r)
+	_ = r.Close()
 	if err != nil {
 		return err
 	}
 
+	for _, format := range pending {
+		var b bytes.Buffer
+		err = format.Sign(key, bytes.NewReader(data.Bytes()), &b)
+		if err != nil {
+			return err
+		}
+
+		err = f.s3.UploadObjectWithOptions(ctx, &b, event.Bucket.Name, format.Key(event.Object.Key), format.ContentType, storage.UploadOptions{
+			Metadata: map[string]*string{
+				storage.MetadataSignedETag: aws.String(etag),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// HandleRequest signs the objects of the events that are signing targets.
+// The key is only loaded once an event is a signing target, so that other events never reach the key provider.
 func (f *LambdaFunction) HandleRequest(ctx context.Context, topic *events.LambdaS3CreateObjectEvent) error {
-	key, err := f.secrets.LoadPrivateKey(ctx)
-	if err != nil {
-		return err
-	}
-
+	var key *openpgp.Entity
 	for _, e := range topic.Events() {
-		err = f.HandleEvent(ctx, key, e)
+		formats := f.rules.Match(e.Object.Key)
+		if len(formats) == 0 {
+			f.l.Log(fmt.Sprintf("skipping s3://%s/%s: not a signing target", e.Bucket.Name, e.Object.Key))
+			continue
+		}
+
+		var err error
+		if key == nil {
+			key, err = f.secrets.LoadPrivateKey(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = f.HandleEvent(ctx, key, e, formats)
 		if err != nil {
 			return err
 		}
@@ -73,15 +208,32 @@ func main() {
 			return err
 		}
 
+		store, err := setup.NewS3(s)
+		if err != nil {
+			return err
+		}
+
 		f := LambdaFunction{
-			l: setup.NewLog("lambda:sign-repo"),
-			s3: &storage.S3{
-				Session: s,
-			},
-			secrets: secrets.NewAmazonKeyProvider(
-				setup.GetEnv(EnvSigningKeySecret),
-				setup.GetEnv(EnvSigningKeyPassphraseSecret, ""),
-				s),
+			l:  setup.NewLog("lambda:sign-repo"),
+			s3: store,
+		}
+
+		f.secrets, err = setup.NewKeyProvider(s)
+		if err != nil {
+			return err
+		}
+
+		formats, err := secrets.ParseSignatureFormats(setup.GetEnv(EnvSignFormats, secrets.FormatArmored.Name), ",")
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvSignFormats)
+		}
+		f.rules.Include, err = ParseTargets(setup.GetEnv(EnvSignInclude, storage.RepoMDXML), formats)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvSignInclude)
+		}
+		f.rules.Exclude, err = ParsePatterns(setup.GetEnv(EnvSignExclude, ""))
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvSignExclude)
 		}
 
 		lambda.Start((&f).HandleRequest)
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"git.illumina.com/relvacode/rpm-lambda/events"
+	"git.illumina.com/relvacode/rpm-lambda/secrets"
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/openpgp"
+	"reflect"
+	"testing"
+)
+
+func TestRulesMatch(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		include string
+		exclude string
+		key     string
+		want    []string
+	}{
+		{"armored", "repodata/repomd.xml", "", "repodata/repomd.xml", []string{"repodata/repomd.xml.asc"}},
+		{"binary", "repodata/repomd.xml=binary", "", "repodata/repomd.xml", []string{"repodata/repomd.xml.sig"}},
+		{"clearsigned", "repodata/repomd.xml=clearsigned", "", "repodata/repomd.xml", []string{"repodata/repomd.xml.clearsigned"}},
+		{"apt release", "dists/*/Release=clearsigned+armored", "", "dists/stable/Release", []string{"dists/stable/InRelease", "dists/stable/Release.asc"}},
+		{"all formats", "repodata/repomd.xml=armored+binary+clearsigned", "", "repodata/repomd.xml",
+			[]string{"repodata/repomd.xml.asc", "repodata/repomd.xml.sig", "repodata/repomd.xml.clearsigned"}},
+		{"first target wins", "repodata/repomd.xml=binary,repodata/*", "", "repodata/repomd.xml", []string{"repodata/repomd.xml.sig"}},
+		{"not a target", "repodata/repomd.xml", "", "repodata/primary.xml.gz", nil},
+		{"pattern matches the whole key", "repomd.xml", "", "repodata/repomd.xml", nil},
+		{"included", "repodata/*", "repodata/*.gz", "repodata/repomd.xml", []string{"repodata/repomd.xml.asc"}},
+		{"excluded", "repodata/*", "repodata/*.gz", "repodata/primary.xml.gz", nil},
+		{"exclude wins over an exact include", "repodata/repomd.xml", "repodata/repomd.*", "repodata/repomd.xml", nil},
+		{"armored signature", "repodata/*", "", "repodata/repomd.xml.asc", nil},
+		{"binary signature", "repodata/*", "", "repodata/repomd.xml.sig", nil},
+		{"clear-signed copy", "repodata/*", "", "repodata/repomd.xml.clearsigned", nil},
+		{"InRelease", "dists/*/*=clearsigned", "", "dists/stable/InRelease", nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				rules Rules
+				err   error
+			)
+			rules.Include, err = ParseTargets(c.include, []secrets.SignatureFormat{secrets.FormatArmored})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules.Exclude, err = ParsePatterns(c.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, f := range rules.Match(c.key) {
+				got = append(got, f.Key(c.key))
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("signatures of %s: got %v, want %v", c.key, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		patterns []string
+		ok       bool
+	}{
+		{"", nil, true},
+		{" repodata/repomd.xml , ,dists/*/Release=clearsigned", []string{"repodata/repomd.xml", "dists/*/Release"}, true},
+		{"repodata/[", nil, false},
+		{"repodata/repomd.xml=pgp", nil, false},
+		{"repodata/repomd.xml=armored+", nil, false},
+	} {
+		targets, err := ParseTargets(c.s, []secrets.SignatureFormat{secrets.FormatArmored})
+		if (err == nil) != c.ok {
+			t.Errorf("%q: error %v", c.s, err)
+			continue
+		}
+		var patterns []string
+		for _, target := range targets {
+			patterns = append(patterns, target.Pattern)
+		}
+		if !reflect.DeepEqual(patterns, c.patterns) {
+			t.Errorf("%q: patterns %v, want %v", c.s, patterns, c.patterns)
+		}
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	patterns, err := ParsePatterns("repodata/*.gz, ,*.sqlite.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"repodata/*.gz", "*.sqlite.bz2"}; !reflect.DeepEqual(patterns, want) {
+		t.Errorf("got %v, want %v", patterns, want)
+	}
+
+	_, err = ParsePatterns("repodata/*.gz,[a-")
+	if err == nil {
+		t.Error("expected an error parsing an invalid pattern")
+	}
+}
+
+// countingProvider is a GPGProvider counting the keys loaded from it.
+type countingProvider struct {
+	loads int
+}
+
+func (p *countingProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	p.loads++
+	return nil, secrets.ErrSecretsKeyNotFound
+}
+
+func TestHandleRequestSkipsKeyOfOtherObjects(t *testing.T) {
+	var topic events.LambdaS3CreateObjectEvent
+	err := json.Unmarshal([]byte(`{"Records": [
+		{"s3": {"bucket": {"name": "repo"}, "object": {"key": "repodata/primary.xml.gz"}}},
+		{"s3": {"bucket": {"name": "repo"}, "object": {"key": "repodata/repomd.xml.asc"}}}
+	]}`), &topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countingProvider{}
+	targets, err := ParseTargets("repodata/repomd.xml", []secrets.SignatureFormat{secrets.FormatArmored})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &LambdaFunction{
+		l:       aws.LoggerFunc(func(...interface{}) {}),
+		secrets: provider,
+		rules:   Rules{Include: targets},
+	}
+
+	err = f.HandleRequest(context.Background(), &topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.loads != 0 {
+		t.Errorf("key loaded %d times for objects that are not signed", provider.loads)
+	}
+
+	// the key is loaded for a signing target
+	topic.Records = topic.Records[:1]
+	topic.Records[0].S3.Object.Key = "repodata/repomd.xml"
+	err = f.HandleRequest(context.Background(), &topic)
+	if err != secrets.ErrSecretsKeyNotFound || provider.loads != 1 {
+		t.Errorf("signing target: error %v after %d loads, want %v after 1", err, provider.loads, secrets.ErrSecretsKeyNotFound)
+	}
+}
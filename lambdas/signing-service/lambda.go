@@ -0,0 +1,73 @@
+// Lambda - Signing Service
+// Signs digests for lambdas configured with a lambda:// or https:// signing key, so that the private key is only ever held
+// by this service. Only digests and signatures are exchanged with its callers.
+package main // import "git.illumina.com/relvacode/rpm-lambda/lambdas/signing-service"
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"git.illumina.com/relvacode/rpm-lambda/secrets"
+	"git.illumina.com/relvacode/rpm-lambda/setup"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+const (
+	// EnvHTTPAddress serves the signing service over HTTP on this address instead of as a lambda, e.g. :8080
+	EnvHTTPAddress = `LAMBDA_HTTP_ADDRESS`
+	// EnvHTTPToken is the token shared with HTTP clients to authenticate their requests
+	EnvHTTPToken = `LAMBDA_HTTP_TOKEN`
+)
+
+type LambdaFunction struct {
+	l       aws.Logger
+	service *secrets.SigningService
+}
+
+// HandleRequest handles a request of a remote signer, logging every digest that is signed.
+func (f *LambdaFunction) HandleRequest(ctx context.Context, req secrets.ServiceRequest) (*secrets.ServiceResponse, error) {
+	resp, err := f.service.Handle(ctx, req)
+	if err != nil {
+		f.l.Log(fmt.Sprintf("%s request failed: %s", req.Action, err))
+		return nil, err
+	}
+	if req.Action == secrets.ActionSign {
+		f.l.Log(fmt.Sprintf("signed %s digest %s with key %s", req.Hash, hex.EncodeToString(req.Digest), req.KeyID))
+	}
+	return resp, nil
+}
+
+func main() {
+	setup.Main(func() error {
+		s, err := setup.NewSession()
+		if err != nil {
+			return err
+		}
+
+		provider, err := setup.NewKeyProvider(s)
+		if err != nil {
+			return err
+		}
+
+		f := LambdaFunction{
+			l:       setup.NewLog("lambda:signing-service"),
+			service: &secrets.SigningService{Signer: &secrets.LocalSigner{GPGProvider: provider}},
+		}
+
+		// the service is served over HTTP instead of Lambda invoke when it runs outside of Lambda
+		if addr := setup.GetEnv(EnvHTTPAddress, ""); addr != "" {
+			token := setup.GetEnv(EnvHTTPToken, "")
+			if token == "" {
+				return errors.Errorf("%s is required with %s", EnvHTTPToken, EnvHTTPAddress)
+			}
+			f.l.Log(fmt.Sprintf("serving HTTP on %s", addr))
+			return http.ListenAndServe(addr, secrets.NewServiceHandler(f.HandleRequest, []byte(token)))
+		}
+
+		lambda.Start((&f).HandleRequest)
+		return nil
+	})
+}
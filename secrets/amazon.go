@@ -3,13 +3,35 @@ package secrets
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"golang.org/x/crypto/openpgp"
 )
 
+// Formats of the private key secret of an AmazonKeyProvider
+const (
+	// SecretFormatRaw holds the armored private key in SecretBinary or SecretString, the passphrase in another secret
+	SecretFormatRaw = "raw"
+	// SecretFormatJSON holds a JSON object with private_key and passphrase fields in SecretString
+	SecretFormatJSON = "json"
+)
+
+// Version stages of Amazon secrets
+const (
+	VersionStageCurrent = "AWSCURRENT"
+	VersionStagePending = "AWSPENDING"
+)
+
+// keySecret is the content of a private key secret in SecretFormatJSON
+type keySecret struct {
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+}
+
 func NewAmazonKeyProvider(pkSecretName, passphraseSecretName string, session *session.Session) *AmazonKeyProvider {
 	return &AmazonKeyProvider{
 		PrivateKeySecret: pkSecretName,
@@ -21,27 +43,82 @@ func NewAmazonKeyProvider(pkSecretName, passphraseSecretName string, session *se
 type AmazonKeyProvider struct {
 	PrivateKeySecret string
 	PassphraseSecret string
-	secrets          *secretsmanager.SecretsManager
+	// KeyID selects the signing key within the keyring
+	KeyID KeyID
+	// Format of the private key secret, SecretFormatRaw if empty
+	Format string
+	// VersionStage pins the version stage of the secrets that is read, AWSCURRENT if empty
+	VersionStage string
+	secrets      *secretsmanager.SecretsManager
+}
+
+func (provider *AmazonKeyProvider) versionStage() string {
+	if provider.VersionStage == "" {
+		return VersionStageCurrent
+	}
+	return provider.VersionStage
 }
 
 func (provider *AmazonKeyProvider) GetBytesSecret(ctx context.Context, k string) ([]byte, error) {
 	result, err := provider.secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(k),
+		SecretId:     aws.String(k),
+		VersionStage: aws.String(provider.versionStage()),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	if result.SecretBinary == nil || len(result.SecretBinary) == 0 {
-		return nil, errors.New("no SecretBinary in Amazon secret")
+	// secrets created in the console only have a SecretString
+	value := result.SecretBinary
+	if len(value) == 0 && result.SecretString != nil {
+		value = []byte(*result.SecretString)
+	}
+	if len(value) == 0 {
+		return nil, errors.New("secrets: no SecretBinary or SecretString in Amazon secret")
 	}
 
 	// Haven't figured out quite why yet but when creating a secret with the CLI somewhere
 	// along the line a new-line character is added.
-	return bytes.TrimRight(result.SecretBinary, "\n"), nil
+	return bytes.TrimRight(value, "\n"), nil
+}
+
+// secretVersion returns the ID of the version of a secret at the version stage of the provider.
+func (provider *AmazonKeyProvider) secretVersion(ctx context.Context, k string) (string, error) {
+	result, err := provider.secrets.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(k),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for id, stages := range result.VersionIdsToStages {
+		for _, stage := range stages {
+			if aws.StringValue(stage) == provider.versionStage() {
+				return id, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("secrets: no %s version of Amazon secret", provider.versionStage())
 }
 
-// LoadPrivateKey obtains the GPG_PRIVATE_KEY from an AWS PrivateKeySecret and decrypts it using GPG_PASSPHRASE
+// KeyVersion returns the versions of the private key and passphrase secrets at the version stage, without reading their values.
+func (provider *AmazonKeyProvider) KeyVersion(ctx context.Context) (string, error) {
+	version, err := provider.secretVersion(ctx, provider.PrivateKeySecret)
+	if err != nil {
+		return "", err
+	}
+	if provider.PassphraseSecret != "" {
+		passphraseVersion, err := provider.secretVersion(ctx, provider.PassphraseSecret)
+		if err != nil {
+			return "", err
+		}
+		version += "/" + passphraseVersion
+	}
+	return version, nil
+}
+
+// LoadPrivateKey obtains the GPG_PRIVATE_KEY from an AWS PrivateKeySecret and decrypts it using GPG_PASSPHRASE.
+// In SecretFormatJSON the passphrase is read from the private key secret, unless it is empty there.
 func (provider *AmazonKeyProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
 	key, err := provider.GetBytesSecret(ctx, provider.PrivateKeySecret)
 	if err != nil {
@@ -49,12 +126,29 @@ func (provider *AmazonKeyProvider) LoadPrivateKey(ctx context.Context) (*openpgp
 	}
 
 	var passphrase []byte
-	if provider.PassphraseSecret != "" {
+	switch provider.Format {
+	case "", SecretFormatRaw:
+	case SecretFormatJSON:
+		var secret keySecret
+		err = json.Unmarshal(key, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: private key secret is not a JSON object: %s", err)
+		}
+		if secret.PrivateKey == "" {
+			return nil, errors.New("secrets: no private_key in JSON secret")
+		}
+		// passphrases are trimmed the same way as secret values
+		key, passphrase = []byte(secret.PrivateKey), bytes.TrimRight([]byte(secret.Passphrase), "\n")
+	default:
+		return nil, fmt.Errorf("secrets: unknown secret format %q", provider.Format)
+	}
+
+	if len(passphrase) == 0 && provider.PassphraseSecret != "" {
 		passphrase, err = provider.GetBytesSecret(ctx, provider.PassphraseSecret)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return DecryptKey(bytes.NewReader(key), passphrase)
+	return DecryptSigningKey(bytes.NewReader(key), passphrase, provider.KeyID)
 }
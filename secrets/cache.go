@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"context"
+	"golang.org/x/crypto/openpgp"
+	"sync"
+	"time"
+)
+
+// VersionedProvider is a GPGProvider that can tell the version of its key without loading it.
+type VersionedProvider interface {
+	GPGProvider
+	// KeyVersion returns an identifier that changes whenever the key or its passphrase change
+	KeyVersion(ctx context.Context) (string, error)
+}
+
+// CachingProvider keeps the decrypted key of a provider between invocations of a warm lambda container.
+// The key is reused until TTL has passed since it was loaded or last found to be current.
+// If the provider is a VersionedProvider the key is only loaded and decrypted again once its version changes,
+// otherwise it is reloaded every TTL.
+type CachingProvider struct {
+	GPGProvider
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entity  *openpgp.Entity
+	version string
+	checked time.Time
+	// now is the clock of the cache, time.Now if nil
+	now func() time.Time
+}
+
+// LoadPrivateKey returns the cached key, or loads it from the wrapped provider.
+func (provider *CachingProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	now := time.Now()
+	if provider.now != nil {
+		now = provider.now()
+	}
+	if provider.entity != nil && now.Sub(provider.checked) < provider.TTL {
+		return provider.entity, nil
+	}
+
+	versioned, ok := provider.GPGProvider.(VersionedProvider)
+
+	var version string
+	if ok {
+		var err error
+		version, err = versioned.KeyVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if provider.entity != nil && version == provider.version {
+			provider.checked = now
+			return provider.entity, nil
+		}
+	}
+
+	// the version is taken before loading, a change in between is picked up by the next check
+	entity, err := provider.GPGProvider.LoadPrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.entity = entity
+	provider.version = version
+	provider.checked = now
+	return entity, nil
+}
@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/openpgp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingProvider is a GPGProvider counting the keys it loads.
+// Each load returns a new entity, or err if set.
+type countingProvider struct {
+	mu    sync.Mutex
+	loads int
+	err   error
+}
+
+func (p *countingProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loads++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &openpgp.Entity{}, nil
+}
+
+// versionedProvider is a countingProvider of a key with a version.
+type versionedProvider struct {
+	countingProvider
+	version string
+}
+
+func (p *versionedProvider) KeyVersion(ctx context.Context) (string, error) {
+	return p.version, nil
+}
+
+// testClock is a clock that only moves when advanced.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(provider GPGProvider) (*CachingProvider, *testClock) {
+	clock := &testClock{t: time.Unix(1500000000, 0)}
+	return &CachingProvider{GPGProvider: provider, TTL: time.Minute, now: clock.now}, clock
+}
+
+func TestCachingProviderTTL(t *testing.T) {
+	provider := &countingProvider{}
+	cache, clock := newTestCache(provider)
+	ctx := context.Background()
+
+	first, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute - time.Second)
+	cached, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != first || provider.loads != 1 {
+		t.Fatalf("key loaded %d times within the TTL", provider.loads)
+	}
+
+	clock.advance(time.Second)
+	reloaded, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first || provider.loads != 2 {
+		t.Errorf("key loaded %d times once the TTL expired, want 2", provider.loads)
+	}
+}
+
+func TestCachingProviderVersion(t *testing.T) {
+	provider := &versionedProvider{version: "1"}
+	cache, clock := newTestCache(provider)
+	ctx := context.Background()
+
+	first, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unchanged version keeps the key past the TTL
+	clock.advance(2 * time.Minute)
+	cached, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != first || provider.loads != 1 {
+		t.Fatalf("key of an unchanged version loaded %d times", provider.loads)
+	}
+
+	// a new version is only picked up once the TTL expires again
+	provider.version = "2"
+	clock.advance(time.Second)
+	cached, err = cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != first {
+		t.Fatal("key version checked within the TTL")
+	}
+
+	clock.advance(time.Minute)
+	reloaded, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first || provider.loads != 2 {
+		t.Errorf("key loaded %d times after the version changed, want 2", provider.loads)
+	}
+}
+
+func TestCachingProviderReloadsOnError(t *testing.T) {
+	provider := &countingProvider{err: errors.New("unavailable")}
+	cache, clock := newTestCache(provider)
+	ctx := context.Background()
+
+	_, err := cache.LoadPrivateKey(ctx)
+	if err != provider.err {
+		t.Fatalf("got %v, want %v", err, provider.err)
+	}
+
+	// failures are not cached
+	provider.err = nil
+	first, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.loads != 2 {
+		t.Fatalf("key loaded %d times after a failure, want 2", provider.loads)
+	}
+
+	// a failed reload keeps trying rather than returning the expired key
+	provider.err = errors.New("unavailable")
+	clock.advance(time.Minute)
+	_, err = cache.LoadPrivateKey(ctx)
+	if err != provider.err {
+		t.Fatalf("got %v, want %v", err, provider.err)
+	}
+	provider.err = nil
+	reloaded, err := cache.LoadPrivateKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first || provider.loads != 4 {
+		t.Errorf("key loaded %d times, want 4", provider.loads)
+	}
+}
+
+func TestCachingProviderConcurrent(t *testing.T) {
+	provider := &countingProvider{}
+	cache, _ := newTestCache(provider)
+
+	var (
+		wg       sync.WaitGroup
+		entities = make([]*openpgp.Entity, 16)
+		errs     = make([]error, len(entities))
+	)
+	for i := range entities {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entities[i], errs[i] = cache.LoadPrivateKey(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range entities {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if entities[i] != entities[0] {
+			t.Fatal("concurrent loads returned different keys")
+		}
+	}
+	if provider.loads != 1 {
+		t.Errorf("key loaded %d times by concurrent callers, want 1", provider.loads)
+	}
+}
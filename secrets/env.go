@@ -0,0 +1,30 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"os"
+	"strings"
+)
+
+// EnvGPGProvider reads an armored private key and its passphrase from environment variables.
+type EnvGPGProvider struct {
+	PrivateKeyVariable string
+	PassphraseVariable string
+	// KeyID selects the signing key within the keyring
+	KeyID KeyID
+}
+
+func (provider *EnvGPGProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	key := os.Getenv(provider.PrivateKeyVariable)
+	if key == "" {
+		return nil, fmt.Errorf("secrets: environment variable %s is empty", provider.PrivateKeyVariable)
+	}
+
+	var passphrase []byte
+	if provider.PassphraseVariable != "" {
+		passphrase = []byte(os.Getenv(provider.PassphraseVariable))
+	}
+	return DecryptSigningKey(strings.NewReader(key), passphrase, provider.KeyID)
+}
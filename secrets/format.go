@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"io"
+	"path"
+	"strings"
+)
+
+// SignatureFormat is a kind of signature uploaded alongside the object it signs.
+type SignatureFormat struct {
+	Name        string
+	ContentType string
+	// Sign signs data from `r` using `key` into `w`
+	Sign func(key *openpgp.Entity, r io.Reader, w io.Writer) error
+
+	suffix string
+	// names are the signatures named by convention rather than by suffix, by the name of the object they sign
+	names map[string]string
+}
+
+// Key returns the key of the signature of the object at `key`.
+func (f SignatureFormat) Key(key string) string {
+	dir, name := path.Split(key)
+	if signature, ok := f.names[name]; ok {
+		return dir + signature
+	}
+	return dir + name + f.suffix
+}
+
+// Source returns the key of the object signed by the signature at `key`,
+// or false if `key` is not a signature of this format.
+func (f SignatureFormat) Source(key string) (string, bool) {
+	dir, name := path.Split(key)
+	for source, signature := range f.names {
+		if name == signature {
+			return dir + source, true
+		}
+	}
+	if !strings.HasSuffix(name, f.suffix) || len(name) <= len(f.suffix) {
+		return "", false
+	}
+	return dir + strings.TrimSuffix(name, f.suffix), true
+}
+
+var (
+	// FormatArmored is an ASCII armored detached signature, e.g. repomd.xml.asc
+	FormatArmored = SignatureFormat{
+		Name:        "armored",
+		ContentType: "application/pgp-signature",
+		Sign:        ArmoredDetachedSign,
+		suffix:      ".asc",
+	}
+	// FormatBinary is a binary detached signature, e.g. repomd.xml.sig
+	FormatBinary = SignatureFormat{
+		Name:        "binary",
+		ContentType: "application/pgp-signature",
+		Sign:        DetachedSign,
+		suffix:      ".sig",
+	}
+	// FormatClearsigned is a clear-signed copy of the object, e.g. repomd.xml.clearsigned.
+	// The clear-signed copy of an apt Release file is InRelease, as apt expects.
+	FormatClearsigned = SignatureFormat{
+		Name:        "clearsigned",
+		ContentType: "text/plain; charset=utf-8",
+		Sign:        ClearSign,
+		suffix:      ".clearsigned",
+		names:       map[string]string{"Release": "InRelease"},
+	}
+)
+
+// SignatureFormats are all supported signature formats.
+var SignatureFormats = []SignatureFormat{FormatArmored, FormatBinary, FormatClearsigned}
+
+// IsSignature returns true if the object at `key` is named as a signature of any format.
+func IsSignature(key string) bool {
+	for _, f := range SignatureFormats {
+		if _, ok := f.Source(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSignatureFormat returns the signature format with the given name.
+func ParseSignatureFormat(name string) (SignatureFormat, error) {
+	for _, f := range SignatureFormats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return SignatureFormat{}, fmt.Errorf("secrets: unknown signature format %q", name)
+}
+
+// ParseSignatureFormats parses a list of signature format names separated by sep.
+func ParseSignatureFormats(s, sep string) ([]SignatureFormat, error) {
+	var formats []SignatureFormat
+	for _, name := range strings.Split(s, sep) {
+		f, err := ParseSignatureFormat(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"bytes"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"testing"
+)
+
+func TestSignatureFormatKey(t *testing.T) {
+	for _, c := range []struct {
+		format    SignatureFormat
+		key       string
+		signature string
+	}{
+		{FormatArmored, "repodata/repomd.xml", "repodata/repomd.xml.asc"},
+		{FormatBinary, "repodata/repomd.xml", "repodata/repomd.xml.sig"},
+		{FormatClearsigned, "repodata/repomd.xml", "repodata/repomd.xml.clearsigned"},
+		{FormatArmored, "dists/stable/Release", "dists/stable/Release.asc"},
+		{FormatBinary, "dists/stable/Release", "dists/stable/Release.sig"},
+		{FormatClearsigned, "dists/stable/Release", "dists/stable/InRelease"},
+		{FormatClearsigned, "Release", "InRelease"},
+		{FormatClearsigned, "dists/stable/Release.gz", "dists/stable/Release.gz.clearsigned"},
+	} {
+		if got := c.format.Key(c.key); got != c.signature {
+			t.Errorf("%s signature of %s: got %s, want %s", c.format.Name, c.key, got, c.signature)
+		}
+		source, ok := c.format.Source(c.signature)
+		if !ok || source != c.key {
+			t.Errorf("%s source of %s: got %s %v, want %s", c.format.Name, c.signature, source, ok, c.key)
+		}
+		if !IsSignature(c.signature) {
+			t.Errorf("%s is not a signature", c.signature)
+		}
+	}
+}
+
+func TestSignatureFormatSource(t *testing.T) {
+	for _, c := range []struct {
+		format SignatureFormat
+		key    string
+	}{
+		{FormatArmored, "repodata/repomd.xml"},
+		{FormatArmored, "repodata/.asc"},
+		{FormatBinary, "repodata/repomd.xml.asc"},
+		{FormatClearsigned, "dists/stable/Release"},
+		{FormatClearsigned, "dists/stable/.clearsigned"},
+	} {
+		if source, ok := c.format.Source(c.key); ok {
+			t.Errorf("%s is a %s signature of %s", c.key, c.format.Name, source)
+		}
+	}
+	if IsSignature("repodata/repomd.xml") {
+		t.Error("repomd.xml is a signature")
+	}
+}
+
+// verifySignature verifies the signature of data made in format, returning the signer.
+func verifySignature(t *testing.T, format SignatureFormat, keyring openpgp.EntityList, data, signature []byte) (*openpgp.Entity, error) {
+	switch format.Name {
+	case FormatArmored.Name:
+		return openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	case FormatBinary.Name:
+		return openpgp.CheckDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	case FormatClearsigned.Name:
+		block, rest := clearsign.Decode(signature)
+		if block == nil || len(rest) > 0 {
+			t.Fatalf("not a clear-signed document:\n%s", signature)
+		}
+		if !bytes.Equal(block.Plaintext, data) {
+			t.Errorf("clear-signed %q, want %q", block.Plaintext, data)
+		}
+		return openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	}
+	t.Fatalf("no verification of %s signatures", format.Name)
+	return nil, nil
+}
+
+func TestSignatureFormatSign(t *testing.T) {
+	entity := newTestEntity(t)
+	keyring := openpgp.EntityList{entity}
+	data := []byte("<repomd>\n  <revision>1</revision>\n</repomd>\n")
+
+	for _, format := range SignatureFormats {
+		t.Run(format.Name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := format.Sign(entity, bytes.NewReader(data), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer, err := verifySignature(t, format, keyring, data, b.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signer != entity {
+				t.Errorf("signed by %v", signer)
+			}
+
+			if format.Name != FormatClearsigned.Name {
+				_, err = verifySignature(t, format, keyring, []byte("<repomd/>\n"), b.Bytes())
+				if err == nil {
+					t.Error("signature verifies other data")
+				}
+			}
+		})
+	}
+}
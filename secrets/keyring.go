@@ -0,0 +1,164 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"strings"
+)
+
+var (
+	ErrSecretsKeyNotFound = errors.New("secrets: no private key in keyring matches the key ID")
+	ErrSecretsNotSigning  = errors.New("secrets: selected key is not capable of signing")
+)
+
+// KeyID selects a key in a keyring by its 64-bit key ID or its 160-bit fingerprint.
+// The zero value selects the primary key of a keyring holding exactly one entity.
+type KeyID struct {
+	id          uint64
+	fingerprint []byte
+}
+
+// ParseKeyID parses a hex key ID (16 digits) or fingerprint (40 digits), as printed by gpg.
+// An optional 0x prefix and any spaces are ignored. An empty string is the zero KeyID.
+func ParseKeyID(s string) (KeyID, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.Replace(s, " ", "", -1)), "0x")
+	if s == "" {
+		return KeyID{}, nil
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return KeyID{}, fmt.Errorf("secrets: invalid key ID %q", s)
+	}
+
+	switch len(b) {
+	case 8:
+		var id uint64
+		for _, c := range b {
+			id = id<<8 | uint64(c)
+		}
+		return KeyID{id: id}, nil
+	case 20:
+		return KeyID{fingerprint: b}, nil
+	}
+	return KeyID{}, fmt.Errorf("secrets: key ID %q is neither a 16 digit key ID nor a 40 digit fingerprint", s)
+}
+
+// IsZero returns true if no key is selected.
+func (k KeyID) IsZero() bool {
+	return k.id == 0 && k.fingerprint == nil
+}
+
+func (k KeyID) matches(key *packet.PublicKey) bool {
+	if k.fingerprint != nil {
+		return bytes.Equal(k.fingerprint, key.Fingerprint[:])
+	}
+	return k.id == key.KeyId
+}
+
+func (k KeyID) String() string {
+	if k.fingerprint != nil {
+		return fmt.Sprintf("%X", k.fingerprint)
+	}
+	return fmt.Sprintf("%016X", k.id)
+}
+
+// SelectKey returns the entity holding the selected private key and that key, which is either its
+// primary key or one of its subkeys. The selected key must carry the signing capability.
+func SelectKey(keyring openpgp.EntityList, id KeyID) (*openpgp.Entity, *packet.PrivateKey, error) {
+	if id.IsZero() {
+		if len(keyring) != 1 {
+			return nil, nil, ErrSecretsInvalidKeyring
+		}
+		return keyring[0], keyring[0].PrivateKey, nil
+	}
+
+	for _, entity := range keyring {
+		if entity.PrivateKey != nil && id.matches(entity.PrimaryKey) {
+			if !canSign(entity.PrimaryKey, primarySelfSignature(entity)) {
+				return nil, nil, ErrSecretsNotSigning
+			}
+			return entity, entity.PrivateKey, nil
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey == nil || !id.matches(subkey.PublicKey) {
+				continue
+			}
+			if !canSign(subkey.PublicKey, subkey.Sig) {
+				return nil, nil, ErrSecretsNotSigning
+			}
+			return entity, subkey.PrivateKey, nil
+		}
+	}
+	return nil, nil, ErrSecretsKeyNotFound
+}
+
+// primarySelfSignature returns the self-signature of the primary identity of an entity, which holds the flags of its primary key.
+// As gpg does, identities flagged as primary are preferred, then the newest self-signature.
+// Identities are kept in a map, so ties are broken by name to stay deterministic.
+func primarySelfSignature(entity *openpgp.Entity) *packet.Signature {
+	var (
+		sig  *packet.Signature
+		name string
+	)
+	for n, ident := range entity.Identities {
+		s := ident.SelfSignature
+		if s == nil {
+			continue
+		}
+		if sig == nil || newerSelfSignature(s, n, sig, name) {
+			sig, name = s, n
+		}
+	}
+	return sig
+}
+
+func isPrimaryID(sig *packet.Signature) bool {
+	return sig.IsPrimaryId != nil && *sig.IsPrimaryId
+}
+
+// newerSelfSignature returns true if the self-signature a of identity an is preferred over b of identity bn.
+func newerSelfSignature(a *packet.Signature, an string, b *packet.Signature, bn string) bool {
+	if isPrimaryID(a) != isPrimaryID(b) {
+		return isPrimaryID(a)
+	}
+	if !a.CreationTime.Equal(b.CreationTime) {
+		return a.CreationTime.After(b.CreationTime)
+	}
+	return an < bn
+}
+
+// DecryptSigningKey reads an armored keyring and decrypts the private key selected by id.
+// Only the selected key is decrypted, so that the primary key of an entity signing with a subkey stays encrypted.
+// The returned entity signs with the selected key: its PrivateKey is replaced by the selected (sub)key,
+// while its primary key, identities and subkeys are unchanged so that signatures verify against the entity.
+func DecryptSigningKey(key io.Reader, passphrase []byte, id KeyID) (*openpgp.Entity, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, private, err := SelectKey(keyring, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if private != nil && private.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, ErrSecretsMissingPassphrase
+		}
+		err = private.Decrypt(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signer := *entity
+	signer.PrivateKey = private
+	return &signer, nil
+}
@@ -1,21 +1,37 @@
 package secrets
 
 import (
+	"bytes"
+	"context"
 	"golang.org/x/crypto/openpgp"
+	"io/ioutil"
 	"os"
 )
 
 type FilepathGPGProvider struct {
 	Filepath   string
 	Passphrase []byte
+	// PassphraseFilepath is read for the passphrase if Passphrase is empty
+	PassphraseFilepath string
+	// KeyID selects the signing key within the keyring
+	KeyID KeyID
 }
 
-func (cs *FilepathGPGProvider) LoadPrivateKey() (*openpgp.Entity, error) {
+func (cs *FilepathGPGProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
 	f, err := os.OpenFile(cs.Filepath, os.O_RDONLY, os.FileMode(0600))
 	if err != nil {
 		return nil, err
 	}
 
 	defer f.Close()
-	return DecryptKey(f, cs.Passphrase)
+
+	passphrase := cs.Passphrase
+	if len(passphrase) == 0 && cs.PassphraseFilepath != "" {
+		passphrase, err = ioutil.ReadFile(cs.PassphraseFilepath)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = bytes.TrimRight(passphrase, "\n")
+	}
+	return DecryptSigningKey(f, passphrase, cs.KeyID)
 }
@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Schemes of provider URIs
+const (
+	// SchemeFile reads the key from a file, e.g. file:///etc/gpg/key.asc?passphrase=/etc/gpg/passphrase
+	SchemeFile = "file"
+	// SchemeEnv reads the key from an environment variable, e.g. env://GPG_PRIVATE_KEY?passphrase=GPG_PASSPHRASE
+	SchemeEnv = "env"
+	// SchemeSecretsManager reads the key from AWS Secrets Manager, e.g. secretsmanager://gpg_key?passphrase=gpg_passphrase&format=raw&stage=AWSCURRENT
+	SchemeSecretsManager = "secretsmanager"
+	// SchemeSSM reads the key from SSM Parameter Store, e.g. ssm:///gpg/key?passphrase=/gpg/passphrase
+	SchemeSSM = "ssm"
+	// SchemeLambda signs through a signing-service lambda, e.g. lambda://signing-service
+	SchemeLambda = "lambda"
+	// SchemeHTTPS and SchemeHTTP sign through a signing service served over HTTP,
+	// authenticated with a token read from an environment variable, e.g. https://signer.internal/sign?token=SIGNING_TOKEN
+	SchemeHTTPS = "https"
+	SchemeHTTP  = "http"
+)
+
+// remoteSignatureTimeout is the timeout of each signature made by a signing service
+const remoteSignatureTimeout = 30 * time.Second
+
+// providerOptions are the query parameters accepted by each scheme
+var providerOptions = map[string][]string{
+	SchemeFile:           {"passphrase"},
+	SchemeEnv:            {"passphrase"},
+	SchemeSecretsManager: {"passphrase", "format", "stage"},
+	SchemeSSM:            {"passphrase"},
+	SchemeLambda:         {},
+	SchemeHTTPS:          {"token"},
+	SchemeHTTP:           {"token"},
+}
+
+// NewProvider returns the GPGProvider described by a URI of the form scheme://location?options.
+// The location is everything between the scheme and the query, so that it may hold secret ARNs and parameter paths.
+// The passphrase option locates the passphrase the same way as the location of the key.
+// Signing service schemes return a SignerProvider, the private key never leaves the service.
+// AWS providers use session, which may be nil for other schemes.
+func NewProvider(uri string, id KeyID, session *session.Session) (GPGProvider, error) {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("secrets: provider %q is not a URI", uri)
+	}
+	scheme, location := uri[:i], uri[i+3:]
+
+	var query string
+	if j := strings.IndexByte(location, '?'); j >= 0 {
+		location, query = location[:j], location[j+1:]
+	}
+	if location == "" {
+		return nil, fmt.Errorf("secrets: provider %q has no location", uri)
+	}
+
+	allowed, ok := providerOptions[scheme]
+	if !ok {
+		return nil, fmt.Errorf("secrets: unknown provider scheme %q", scheme)
+	}
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: invalid options of provider %q: %s", uri, err)
+	}
+	for k := range options {
+		if !containsString(allowed, k) {
+			return nil, fmt.Errorf("secrets: unknown option %q of %s provider", k, scheme)
+		}
+	}
+	passphrase := options.Get("passphrase")
+
+	switch scheme {
+	case SchemeFile:
+		return &FilepathGPGProvider{Filepath: location, PassphraseFilepath: passphrase, KeyID: id}, nil
+	case SchemeEnv:
+		return &EnvGPGProvider{PrivateKeyVariable: location, PassphraseVariable: passphrase, KeyID: id}, nil
+	case SchemeSecretsManager:
+		provider := NewAmazonKeyProvider(location, passphrase, session)
+		provider.KeyID = id
+		provider.Format = options.Get("format")
+		provider.VersionStage = options.Get("stage")
+		if provider.Format != "" && provider.Format != SecretFormatRaw && provider.Format != SecretFormatJSON {
+			return nil, fmt.Errorf("secrets: unknown secret format %q", provider.Format)
+		}
+		return provider, nil
+	case SchemeSSM:
+		provider := NewSSMKeyProvider(location, passphrase, session)
+		provider.KeyID = id
+		return provider, nil
+	case SchemeLambda:
+		return &SignerProvider{
+			Signer:  &RemoteSigner{Transport: NewLambdaTransport(location, session)},
+			KeyID:   id,
+			Timeout: remoteSignatureTimeout,
+		}, nil
+	case SchemeHTTPS, SchemeHTTP:
+		token := os.Getenv(options.Get("token"))
+		if token == "" {
+			return nil, fmt.Errorf("secrets: %s provider requires a token", scheme)
+		}
+		return &SignerProvider{
+			Signer: &RemoteSigner{Transport: &HTTPTransport{
+				URL:    scheme + "://" + location,
+				Token:  []byte(token),
+				Client: &http.Client{Timeout: remoteSignatureTimeout},
+			}},
+			KeyID:   id,
+			Timeout: remoteSignatureTimeout,
+		}, nil
+	}
+	return nil, fmt.Errorf("secrets: unknown provider scheme %q", scheme)
+}
+
+func containsString(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
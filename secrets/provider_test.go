@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// describeProvider describes a provider returned by NewProvider by its kind and location, and returns the key ID it selects.
+func describeProvider(t *testing.T, provider GPGProvider) (string, KeyID) {
+	switch p := provider.(type) {
+	case *FilepathGPGProvider:
+		return fmt.Sprintf("file %s passphrase=%s", p.Filepath, p.PassphraseFilepath), p.KeyID
+	case *EnvGPGProvider:
+		return fmt.Sprintf("env %s passphrase=%s", p.PrivateKeyVariable, p.PassphraseVariable), p.KeyID
+	case *AmazonKeyProvider:
+		return fmt.Sprintf("secretsmanager %s passphrase=%s format=%s stage=%s",
+			p.PrivateKeySecret, p.PassphraseSecret, p.Format, p.VersionStage), p.KeyID
+	case *SSMKeyProvider:
+		return fmt.Sprintf("ssm %s passphrase=%s", p.PrivateKeyParameter, p.PassphraseParameter), p.KeyID
+	case *SignerProvider:
+		if p.Timeout != remoteSignatureTimeout {
+			t.Errorf("signature timeout %s", p.Timeout)
+		}
+		switch tr := p.Signer.(*RemoteSigner).Transport.(type) {
+		case *LambdaTransport:
+			return fmt.Sprintf("lambda %s", tr.Function), p.KeyID
+		case *HTTPTransport:
+			return fmt.Sprintf("http %s token=%s", tr.URL, tr.Token), p.KeyID
+		}
+	}
+	t.Fatalf("unexpected provider %T", provider)
+	return "", KeyID{}
+}
+
+func TestNewProvider(t *testing.T) {
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Setenv("TEST_SIGNING_TOKEN", "token")
+	defer os.Unsetenv("TEST_SIGNING_TOKEN")
+
+	id := mustParseKeyID(t, testSubkeyID)
+	for _, c := range []struct {
+		uri  string
+		want string
+		// err is empty if the URI is valid
+		err string
+	}{
+		{"file:///etc/gpg/key.asc", "file /etc/gpg/key.asc passphrase=", ""},
+		{"file:///etc/gpg/key.asc?passphrase=/etc/gpg/passphrase", "file /etc/gpg/key.asc passphrase=/etc/gpg/passphrase", ""},
+		{"env://GPG_PRIVATE_KEY?passphrase=GPG_PASSPHRASE", "env GPG_PRIVATE_KEY passphrase=GPG_PASSPHRASE", ""},
+		{"secretsmanager://gpg_key", "secretsmanager gpg_key passphrase= format= stage=", ""},
+		{"secretsmanager://arn:aws:secretsmanager:us-east-1:123456789012:secret:gpg/key-AbCdEf?passphrase=gpg_passphrase&format=json&stage=AWSPREVIOUS",
+			"secretsmanager arn:aws:secretsmanager:us-east-1:123456789012:secret:gpg/key-AbCdEf passphrase=gpg_passphrase format=json stage=AWSPREVIOUS", ""},
+		{"ssm:///gpg/key?passphrase=/gpg/passphrase", "ssm /gpg/key passphrase=/gpg/passphrase", ""},
+		{"lambda://signing-service", "lambda signing-service", ""},
+		{"lambda://arn:aws:lambda:us-east-1:123456789012:function:signing-service", "lambda arn:aws:lambda:us-east-1:123456789012:function:signing-service", ""},
+		{"https://signer.internal/sign?token=TEST_SIGNING_TOKEN", "http https://signer.internal/sign token=token", ""},
+		{"http://localhost:8080/sign?token=TEST_SIGNING_TOKEN", "http http://localhost:8080/sign token=token", ""},
+
+		{"/etc/gpg/key.asc", "", `secrets: provider "/etc/gpg/key.asc" is not a URI`},
+		{"vault://gpg/key", "", `secrets: unknown provider scheme "vault"`},
+		{"://gpg/key", "", `secrets: unknown provider scheme ""`},
+		{"file://", "", `secrets: provider "file://" has no location`},
+		{"env://?passphrase=GPG_PASSPHRASE", "", `secrets: provider "env://?passphrase=GPG_PASSPHRASE" has no location`},
+		{"env://GPG_PRIVATE_KEY?stage=AWSCURRENT", "", `secrets: unknown option "stage" of env provider`},
+		{"lambda://signing-service?passphrase=x", "", `secrets: unknown option "passphrase" of lambda provider`},
+		{"ssm:///gpg/key?passphrase=%zz", "", `secrets: invalid options of provider "ssm:///gpg/key?passphrase=%zz": invalid URL escape "%zz"`},
+		{"secretsmanager://gpg_key?format=yaml", "", `secrets: unknown secret format "yaml"`},
+		{"https://signer.internal/sign", "", "secrets: https provider requires a token"},
+		{"https://signer.internal/sign?token=TEST_MISSING_TOKEN", "", "secrets: https provider requires a token"},
+	} {
+		t.Run(c.uri, func(t *testing.T) {
+			provider, err := NewProvider(c.uri, id, s)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("got error %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, keyID := describeProvider(t, provider)
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+			if !reflect.DeepEqual(keyID, id) {
+				t.Errorf("provider selects key %+v, want %+v", keyID, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"golang.org/x/crypto/openpgp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport sends requests to a signing service.
+type Transport interface {
+	RoundTrip(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error)
+}
+
+// RemoteSigner is a Signer backed by a signing service, only digests and signatures are exchanged with the service.
+type RemoteSigner struct {
+	Transport Transport
+}
+
+func (signer *RemoteSigner) PublicKey(ctx context.Context) (*openpgp.Entity, uint64, error) {
+	resp, err := signer.Transport.RoundTrip(ctx, &ServiceRequest{Action: ActionKey})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id, err := strconv.ParseUint(resp.KeyID, 16, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("secrets: signing service returned an invalid key ID %q", resp.KeyID)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(resp.PublicKey))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(keyring) != 1 {
+		return nil, 0, ErrSecretsInvalidKeyring
+	}
+	return keyring[0], id, nil
+}
+
+func (signer *RemoteSigner) SignDigest(ctx context.Context, keyID uint64, hash crypto.Hash, digest []byte) ([]byte, error) {
+	name, err := hashName(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := signer.Transport.RoundTrip(ctx, &ServiceRequest{
+		Action: ActionSign,
+		KeyID:  fmt.Sprintf("%016X", keyID),
+		Hash:   name,
+		Digest: digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Signature) == 0 {
+		return nil, fmt.Errorf("secrets: signing service returned no signature")
+	}
+	return resp.Signature, nil
+}
+
+// HTTPTransport sends requests to a signing service served by NewServiceHandler at URL.
+type HTTPTransport struct {
+	URL string
+	// Token is shared with the signing service to authenticate requests
+	Token  []byte
+	Client *http.Client
+}
+
+func (t *HTTPTransport) RoundTrip(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	date := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(HeaderServiceDate, date)
+	r.Header.Set(HeaderServiceAuthorization, authorizationScheme+hex.EncodeToString(requestMAC(t.Token, date, body)))
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("secrets: signing service: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out ServiceResponse
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func NewLambdaTransport(function string, session *session.Session) *LambdaTransport {
+	return &LambdaTransport{
+		Function: function,
+		lambda:   lambda.New(session),
+	}
+}
+
+// LambdaTransport invokes a signing-service lambda, requests are authenticated by IAM.
+type LambdaTransport struct {
+	// Function is the name or ARN of the signing-service lambda
+	Function string
+	lambda   *lambda.Lambda
+}
+
+func (t *LambdaTransport) RoundTrip(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := t.lambda.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(t.Function),
+		Payload:      payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.FunctionError != nil {
+		var failure struct {
+			ErrorMessage string `json:"errorMessage"`
+		}
+		_ = json.Unmarshal(result.Payload, &failure)
+		return nil, fmt.Errorf("secrets: signing service: %s", failure.ErrorMessage)
+	}
+
+	var out ServiceResponse
+	err = json.Unmarshal(result.Payload, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
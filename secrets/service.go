@@ -0,0 +1,206 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions of the signing service protocol
+const (
+	// ActionKey returns the public key of the service
+	ActionKey = "key"
+	// ActionSign signs a digest
+	ActionSign = "sign"
+)
+
+// ServiceRequest is a request to a signing service, sent as JSON over HTTP or as the payload of a Lambda invocation.
+type ServiceRequest struct {
+	Action string `json:"action"`
+	// KeyID is the ID of the key a sign request expects to be signed with, as printed by gpg
+	KeyID string `json:"key_id,omitempty"`
+	// Hash is the digest algorithm of a sign request, e.g. SHA256
+	Hash   string `json:"hash,omitempty"`
+	Digest []byte `json:"digest,omitempty"`
+}
+
+// ServiceResponse is the response of a signing service.
+type ServiceResponse struct {
+	// KeyID and PublicKey are the ID of the signing (sub)key and the armored public key holding it
+	KeyID     string `json:"key_id,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// serviceHashes are the digest algorithms a signing service signs
+var serviceHashes = map[string]crypto.Hash{
+	"SHA256": crypto.SHA256,
+	"SHA384": crypto.SHA384,
+	"SHA512": crypto.SHA512,
+}
+
+func hashName(hash crypto.Hash) (string, error) {
+	for name, h := range serviceHashes {
+		if h == hash {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("secrets: digest algorithm %d is not supported by the signing service", hash)
+}
+
+// SigningService signs digests for remote signers, so that the private key only exists in the service.
+type SigningService struct {
+	Signer Signer
+}
+
+// Handle handles a request of a remote signer. It is the handler of the signing-service lambda.
+func (service *SigningService) Handle(ctx context.Context, req ServiceRequest) (*ServiceResponse, error) {
+	switch req.Action {
+	case ActionKey:
+		entity, id, err := service.Signer.PublicKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var b bytes.Buffer
+		w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+		if err != nil {
+			return nil, err
+		}
+		err = serializePublic(w, entity)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &ServiceResponse{KeyID: fmt.Sprintf("%016X", id), PublicKey: b.String()}, nil
+	case ActionSign:
+		id, err := strconv.ParseUint(req.KeyID, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: invalid key ID %q", req.KeyID)
+		}
+		hash, ok := serviceHashes[req.Hash]
+		if !ok {
+			return nil, fmt.Errorf("secrets: unsupported digest algorithm %q", req.Hash)
+		}
+		if len(req.Digest) != hash.Size() {
+			return nil, fmt.Errorf("secrets: %s digest must be %d bytes", req.Hash, hash.Size())
+		}
+
+		sig, err := service.Signer.SignDigest(ctx, id, hash, req.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return &ServiceResponse{Signature: sig}, nil
+	}
+	return nil, fmt.Errorf("secrets: unknown signing service action %q", req.Action)
+}
+
+// serializePublic writes the public key of an entity like Entity.Serialize, but keeps its revocation signatures
+// so that clients validating the key see that it has been revoked.
+func serializePublic(w io.Writer, entity *openpgp.Entity) error {
+	err := entity.PrimaryKey.Serialize(w)
+	if err != nil {
+		return err
+	}
+	for _, sig := range entity.Revocations {
+		err = sig.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the rest of the entity is serialized without its primary key
+	var rest bytes.Buffer
+	err = entity.Serialize(&rest)
+	if err != nil {
+		return err
+	}
+	var primary bytes.Buffer
+	err = entity.PrimaryKey.Serialize(&primary)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(rest.Bytes()[primary.Len():])
+	return err
+}
+
+// Headers authenticating HTTP requests to a signing service
+const (
+	HeaderServiceDate          = "X-Signing-Date"
+	HeaderServiceAuthorization = "Authorization"
+
+	authorizationScheme = "HMAC-SHA256 "
+	// maxRequestSkew is how far the date of a request may be from the time it is received
+	maxRequestSkew = 5 * time.Minute
+	// maxRequestSize of a request to a signing service
+	maxRequestSize = 64 << 10
+)
+
+// requestMAC authenticates the date and body of a request with a token shared by a signing service and its clients.
+func requestMAC(token []byte, date string, body []byte) []byte {
+	mac := hmac.New(sha256.New, token)
+	_, _ = io.WriteString(mac, date+"\n")
+	_, _ = mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// ServiceHandlerFunc handles requests to a signing service, such as SigningService.Handle.
+type ServiceHandlerFunc func(ctx context.Context, req ServiceRequest) (*ServiceResponse, error)
+
+// NewServiceHandler returns an http.Handler serving a signing service to clients sharing token.
+// Requests are POSTed as JSON and authenticated by an HMAC-SHA256 of their date and body.
+func NewServiceHandler(handle ServiceHandlerFunc, token []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		date := r.Header.Get(HeaderServiceDate)
+		unix, err := strconv.ParseInt(date, 10, 64)
+		skew := time.Since(time.Unix(unix, 0))
+		mac, macErr := hex.DecodeString(strings.TrimPrefix(r.Header.Get(HeaderServiceAuthorization), authorizationScheme))
+		if err != nil || skew > maxRequestSkew || skew < -maxRequestSkew || macErr != nil || !hmac.Equal(mac, requestMAC(token, date, body)) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req ServiceRequest
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := handle(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,270 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"git.illumina.com/relvacode/rpm-lambda/signing"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/signing.asc holds a certify-only primary key with a signing subkey, encrypted with the passphrase "test".
+// testdata/revoked.asc holds a revoked signing key, encrypted with the same passphrase.
+const (
+	testPrimaryKeyID = "D8FD397B3AC87976"
+	testSubkeyID     = "AF8C37080C7877B2"
+	testPassphrase   = "test"
+)
+
+var testToken = []byte("signing-service-token")
+
+func mustParseKeyID(t *testing.T, s string) KeyID {
+	t.Helper()
+	id, err := ParseKeyID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// newTestService serves a signing service over HTTP signing with the key in the keyring file.
+func newTestService(t *testing.T, keyring string, id KeyID) *httptest.Server {
+	t.Helper()
+	service := &SigningService{
+		Signer: &LocalSigner{
+			GPGProvider: &FilepathGPGProvider{
+				Filepath:   keyring,
+				Passphrase: []byte(testPassphrase),
+				KeyID:      id,
+			},
+		},
+	}
+	return httptest.NewServer(NewServiceHandler(service.Handle, testToken))
+}
+
+func newTestSigner(server *httptest.Server, token []byte) *RemoteSigner {
+	return &RemoteSigner{Transport: &HTTPTransport{URL: server.URL, Token: token, Client: server.Client()}}
+}
+
+// readPublicKey reads the public key of the entity in a keyring file, as a client verifying signatures has it.
+func readPublicKey(t *testing.T, keyring string) openpgp.EntityList {
+	t.Helper()
+	f, err := os.Open(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entities
+}
+
+func loadRemoteKey(t *testing.T, server *httptest.Server) *openpgp.Entity {
+	t.Helper()
+	provider := &SignerProvider{
+		Signer:  newTestSigner(server, testToken),
+		KeyID:   mustParseKeyID(t, testSubkeyID),
+		Timeout: remoteSignatureTimeout,
+	}
+	entity, err := provider.LoadPrivateKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := signing.KeyID(entity.PrivateKey.KeyId); got != testSubkeyID {
+		t.Fatalf("signing key is %s, want %s", got, testSubkeyID)
+	}
+	return entity
+}
+
+func TestServiceSignsRPM(t *testing.T) {
+	server := newTestService(t, "testdata/signing.asc", mustParseKeyID(t, testSubkeyID))
+	defer server.Close()
+	entity := loadRemoteKey(t, server)
+	public := readPublicKey(t, "testdata/signing.asc")
+
+	for _, opts := range []signing.Options{{}, {HeaderOnly: true}} {
+		f, err := os.Open("testdata/simple-1.0.1-1.i386.rpm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := signing.Sign(f, entity.PrivateKey, opts)
+		if err != nil {
+			f.Close()
+			t.Fatalf("HeaderOnly=%t: sign: %v", opts.HeaderOnly, err)
+		}
+		_, err = f.Seek(signed.Offset, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rpm bytes.Buffer
+		_, err = signed.WriteTo(&rpm, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sigs, err := signing.ReadSignatures(bytes.NewReader(rpm.Bytes()))
+		if err != nil {
+			t.Fatalf("HeaderOnly=%t: read signatures: %v", opts.HeaderOnly, err)
+		}
+		if !sigs.SignedBy(entity.PrivateKey.KeyId) {
+			t.Errorf("HeaderOnly=%t: RPM is not signed by %s: %s", opts.HeaderOnly, testSubkeyID, sigs)
+		}
+		err = signing.Verify(bytes.NewReader(rpm.Bytes()), public[0])
+		if err != nil {
+			t.Errorf("HeaderOnly=%t: verify: %v", opts.HeaderOnly, err)
+		}
+	}
+}
+
+func TestServiceDetachedSignatures(t *testing.T) {
+	server := newTestService(t, "testdata/signing.asc", mustParseKeyID(t, testSubkeyID))
+	defer server.Close()
+	entity := loadRemoteKey(t, server)
+	public := readPublicKey(t, "testdata/signing.asc")
+	data := "<repomd/>\n"
+
+	var armored bytes.Buffer
+	err := ArmoredDetachedSign(entity, strings.NewReader(data), &armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := openpgp.CheckArmoredDetachedSignature(public, strings.NewReader(data), &armored)
+	if err != nil {
+		t.Fatalf("armored signature: %v", err)
+	}
+	if signer.PrimaryKey.KeyId != public[0].PrimaryKey.KeyId {
+		t.Errorf("armored signature is signed by %X", signer.PrimaryKey.KeyId)
+	}
+
+	var binary bytes.Buffer
+	err = DetachedSign(entity, strings.NewReader(data), &binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = openpgp.CheckDetachedSignature(public, strings.NewReader(data), &binary)
+	if err != nil {
+		t.Fatalf("binary signature: %v", err)
+	}
+
+	var clear bytes.Buffer
+	err = ClearSign(entity, strings.NewReader(data), &clear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := clearsign.Decode(clear.Bytes())
+	if block == nil {
+		t.Fatal("clear-signed message has no signed block")
+	}
+	_, err = openpgp.CheckDetachedSignature(public, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		t.Fatalf("clear-signed message: %v", err)
+	}
+}
+
+// post sends a sign request to the service authenticated with token at date.
+func post(t *testing.T, server *httptest.Server, token []byte, date time.Time) int {
+	t.Helper()
+	body, err := json.Marshal(&ServiceRequest{Action: ActionKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := strconv.FormatInt(date.Unix(), 10)
+	r.Header.Set(HeaderServiceDate, d)
+	r.Header.Set(HeaderServiceAuthorization, authorizationScheme+hex.EncodeToString(requestMAC(token, d, body)))
+
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServiceAuthentication(t *testing.T) {
+	server := newTestService(t, "testdata/signing.asc", mustParseKeyID(t, testSubkeyID))
+	defer server.Close()
+	now := time.Now()
+
+	for _, c := range []struct {
+		name   string
+		token  []byte
+		date   time.Time
+		status int
+	}{
+		{"valid", testToken, now, http.StatusOK},
+		{"bad HMAC", []byte("wrong-token"), now, http.StatusUnauthorized},
+		{"date in the past", testToken, now.Add(-2 * maxRequestSkew), http.StatusUnauthorized},
+		{"date in the future", testToken, now.Add(2 * maxRequestSkew), http.StatusUnauthorized},
+	} {
+		if status := post(t, server, c.token, c.date); status != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, status, c.status)
+		}
+	}
+
+	_, _, err := newTestSigner(server, []byte("wrong-token")).PublicKey(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("remote signer with a bad token: %v, want 401 Unauthorized", err)
+	}
+}
+
+func TestServiceWrongKeyID(t *testing.T) {
+	server := newTestService(t, "testdata/signing.asc", mustParseKeyID(t, testSubkeyID))
+	defer server.Close()
+
+	provider := &SignerProvider{
+		Signer: newTestSigner(server, testToken),
+		KeyID:  mustParseKeyID(t, testPrimaryKeyID),
+	}
+	_, err := provider.LoadPrivateKey(context.Background())
+	if err != ErrSecretsWrongKey {
+		t.Errorf("expecting key %s: %v, want %v", testPrimaryKeyID, err, ErrSecretsWrongKey)
+	}
+
+	// a sign request for another key than the one the service signs with is refused by the service
+	primary, err := strconv.ParseUint(testPrimaryKeyID, 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := make([]byte, 32)
+	_, err = newTestSigner(server, testToken).SignDigest(context.Background(), primary, crypto.SHA256, digest)
+	if err == nil || !strings.Contains(err.Error(), ErrSecretsWrongKey.Error()) {
+		t.Errorf("signing with key %s: %v, want %v", testPrimaryKeyID, err, ErrSecretsWrongKey)
+	}
+}
+
+func TestServiceKeepsRevocations(t *testing.T) {
+	server := newTestService(t, "testdata/revoked.asc", KeyID{})
+	defer server.Close()
+
+	provider := &SignerProvider{Signer: newTestSigner(server, testToken)}
+	entity, err := provider.LoadPrivateKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entity.Revocations) == 0 {
+		t.Fatal("public key served by the signing service lost its revocation signature")
+	}
+	_, err = ValidateSigningKey(entity, time.Now())
+	if e, ok := err.(*KeyError); !ok || e.Err != ErrSecretsKeyRevoked {
+		t.Errorf("validating the key served by the signing service: %v, want %v", err, ErrSecretsKeyRevoked)
+	}
+}
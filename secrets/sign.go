@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
 	"io"
 )
 
@@ -21,25 +22,26 @@ func DetachedSign(key *openpgp.Entity, r io.Reader, w io.Writer) error {
 	return openpgp.DetachSign(w, key, r, nil)
 }
 
-func DecryptKey(key io.Reader, passphrase []byte) (*openpgp.Entity, error) {
-	keyring, err := openpgp.ReadArmoredKeyRing(key)
+// ArmoredDetachedSign signs data from `r` using `key` into the ASCII armored signature stream `w`.
+func ArmoredDetachedSign(key *openpgp.Entity, r io.Reader, w io.Writer) error {
+	return openpgp.ArmoredDetachSign(w, key, r, nil)
+}
+
+// ClearSign writes data from `r` to `w` as a clear-signed message signed using `key`.
+func ClearSign(key *openpgp.Entity, r io.Reader, w io.Writer) error {
+	pw, err := clearsign.Encode(w, key.PrivateKey, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if len(keyring) != 1 {
-		return nil, ErrSecretsInvalidKeyring
+	_, err = io.Copy(pw, r)
+	if err != nil {
+		return err
 	}
+	return pw.Close()
+}
 
-	entity := keyring[0]
-	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
-		if len(passphrase) == 0 {
-			return nil, ErrSecretsMissingPassphrase
-		}
-		err = entity.PrivateKey.Decrypt(passphrase)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return entity, nil
+// DecryptKey reads an armored keyring holding exactly one entity and decrypts its primary key.
+func DecryptKey(key io.Reader, passphrase []byte) (*openpgp.Entity, error) {
+	return DecryptSigningKey(key, passphrase, KeyID{})
 }
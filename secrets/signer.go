@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"time"
+)
+
+var (
+	ErrSecretsWrongKey       = errors.New("secrets: signer does not hold the requested key")
+	ErrSecretsUnsupportedKey = errors.New("secrets: key algorithm does not support signing digests")
+)
+
+// Signer signs digests with a private key that is never disclosed, such as the key of a signing service.
+type Signer interface {
+	// PublicKey returns the public entity of the signing key and the ID of the (sub)key that signs
+	PublicKey(ctx context.Context) (*openpgp.Entity, uint64, error)
+	// SignDigest signs a digest made with hash using the key with keyID
+	SignDigest(ctx context.Context, keyID uint64, hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// LocalSigner signs with the private key loaded by a GPGProvider.
+type LocalSigner struct {
+	GPGProvider
+}
+
+func (signer *LocalSigner) PublicKey(ctx context.Context) (*openpgp.Entity, uint64, error) {
+	entity, err := signer.LoadPrivateKey(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entity, entity.PrivateKey.KeyId, nil
+}
+
+// SignDigest signs a digest the same way OpenPGP signatures are made, PKCS #1 v1.5 for RSA keys and ASN.1 encoded for ECDSA keys.
+func (signer *LocalSigner) SignDigest(ctx context.Context, keyID uint64, hash crypto.Hash, digest []byte) ([]byte, error) {
+	entity, err := signer.LoadPrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if entity.PrivateKey.KeyId != keyID {
+		return nil, ErrSecretsWrongKey
+	}
+
+	key, ok := entity.PrivateKey.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrSecretsUnsupportedKey
+	}
+	return key.Sign(rand.Reader, digest, hash)
+}
+
+// SignerProvider is a GPGProvider of entities that sign through a Signer, so that their private key is never loaded.
+// The private key of the returned entity is only usable for signing.
+type SignerProvider struct {
+	Signer Signer
+	// KeyID is the expected signing key, any key of the signer is accepted if zero
+	KeyID KeyID
+	// Timeout of each signature, signatures are made outside of the context the entity is loaded in
+	Timeout time.Duration
+}
+
+func (provider *SignerProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	entity, id, err := provider.Signer.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub *packet.PublicKey
+	if entity.PrimaryKey.KeyId == id {
+		pub = entity.PrimaryKey
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PublicKey.KeyId == id {
+			pub = subkey.PublicKey
+		}
+	}
+	if pub == nil {
+		return nil, ErrSecretsKeyNotFound
+	}
+	if !provider.KeyID.IsZero() && !provider.KeyID.matches(pub) {
+		return nil, ErrSecretsWrongKey
+	}
+	// the signature is made by digestSigner, which OpenPGP only uses to sign with RSA and ECDSA keys
+	switch pub.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoECDSA:
+	default:
+		return nil, ErrSecretsUnsupportedKey
+	}
+
+	signer := *entity
+	signer.PrivateKey = &packet.PrivateKey{
+		PublicKey: *pub,
+		PrivateKey: &digestSigner{
+			signer:  provider.Signer,
+			keyID:   id,
+			public:  pub.PublicKey,
+			timeout: provider.Timeout,
+		},
+	}
+	return &signer, nil
+}
+
+// digestSigner is a crypto.Signer signing through a Signer, as used by OpenPGP signatures over RSA and ECDSA keys.
+type digestSigner struct {
+	signer  Signer
+	keyID   uint64
+	public  crypto.PublicKey
+	timeout time.Duration
+}
+
+func (s *digestSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *digestSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return s.signer.SignDigest(ctx, s.keyID, opts.HashFunc(), digest)
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/dsa"
+	"crypto/rand"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"testing"
+	"time"
+)
+
+// publicSigner is a Signer of a public entity that cannot sign.
+type publicSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s *publicSigner) PublicKey(ctx context.Context) (*openpgp.Entity, uint64, error) {
+	return s.entity, s.entity.PrimaryKey.KeyId, nil
+}
+
+func (s *publicSigner) SignDigest(ctx context.Context, keyID uint64, hash crypto.Hash, digest []byte) ([]byte, error) {
+	return nil, ErrSecretsUnsupportedKey
+}
+
+func TestSignerProviderRejectsDSA(t *testing.T) {
+	var key dsa.PrivateKey
+	err := dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dsa.GenerateKey(&key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &SignerProvider{Signer: &publicSigner{
+		entity: &openpgp.Entity{PrimaryKey: packet.NewDSAPublicKey(time.Now(), &key.PublicKey)},
+	}}
+	_, err = provider.LoadPrivateKey(context.Background())
+	if err != ErrSecretsUnsupportedKey {
+		t.Fatalf("expected %v loading a DSA key, got %v", ErrSecretsUnsupportedKey, err)
+	}
+}
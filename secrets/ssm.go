@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"golang.org/x/crypto/openpgp"
+)
+
+func NewSSMKeyProvider(pkParameterName, passphraseParameterName string, session *session.Session) *SSMKeyProvider {
+	return &SSMKeyProvider{
+		PrivateKeyParameter: pkParameterName,
+		PassphraseParameter: passphraseParameterName,
+		ssm:                 ssm.New(session),
+	}
+}
+
+// SSMKeyProvider reads the private key and its passphrase from SSM Parameter Store, typically as SecureString parameters.
+type SSMKeyProvider struct {
+	PrivateKeyParameter string
+	PassphraseParameter string
+	// KeyID selects the signing key within the keyring
+	KeyID KeyID
+	ssm   *ssm.SSM
+}
+
+func (provider *SSMKeyProvider) getParameter(ctx context.Context, name string, decrypt bool) (*ssm.Parameter, error) {
+	result, err := provider.ssm.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(decrypt),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Parameter, nil
+}
+
+// GetBytesParameter returns the decrypted value of a parameter.
+func (provider *SSMKeyProvider) GetBytesParameter(ctx context.Context, name string) ([]byte, error) {
+	p, err := provider.getParameter(ctx, name, true)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight([]byte(aws.StringValue(p.Value)), "\n"), nil
+}
+
+// KeyVersion returns the versions of the private key and passphrase parameters, without decrypting them.
+func (provider *SSMKeyProvider) KeyVersion(ctx context.Context) (string, error) {
+	p, err := provider.getParameter(ctx, provider.PrivateKeyParameter, false)
+	if err != nil {
+		return "", err
+	}
+	version := fmt.Sprint(aws.Int64Value(p.Version))
+
+	if provider.PassphraseParameter != "" {
+		p, err = provider.getParameter(ctx, provider.PassphraseParameter, false)
+		if err != nil {
+			return "", err
+		}
+		version += fmt.Sprintf("/%d", aws.Int64Value(p.Version))
+	}
+	return version, nil
+}
+
+// LoadPrivateKey reads the private key from PrivateKeyParameter and decrypts it using PassphraseParameter.
+func (provider *SSMKeyProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	key, err := provider.GetBytesParameter(ctx, provider.PrivateKeyParameter)
+	if err != nil {
+		return nil, err
+	}
+
+	var passphrase []byte
+	if provider.PassphraseParameter != "" {
+		passphrase, err = provider.GetBytesParameter(ctx, provider.PassphraseParameter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return DecryptSigningKey(bytes.NewReader(key), passphrase, provider.KeyID)
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"time"
+)
+
+var (
+	ErrSecretsNoPrivateKey = errors.New("secrets: entity has no private key")
+	ErrSecretsKeyExpired   = errors.New("secrets: signing key has expired")
+	ErrSecretsKeyRevoked   = errors.New("secrets: signing key has been revoked")
+)
+
+// KeyError records why the key with KeyID cannot be used to sign.
+type KeyError struct {
+	KeyID uint64
+	Err   error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s (key %016X)", e.Err, e.KeyID)
+}
+
+// canSign returns true if a key may sign given the self-signature carrying its key flags.
+// Keys without key flags may sign if their algorithm can.
+func canSign(key *packet.PublicKey, sig *packet.Signature) bool {
+	return key.PubKeyAlgo.CanSign() && (sig == nil || !sig.FlagsValid || sig.FlagSign)
+}
+
+// keyExpiry returns when a key expires given its self-signature, or the zero time if it never expires.
+// The key lifetime is counted from the creation of the key.
+func keyExpiry(key *packet.PublicKey, sig *packet.Signature) time.Time {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return key.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// ValidateSigningKey checks that the private key of an entity may sign at `now`.
+// The key must not be revoked or expired and must carry the signing capability.
+// A subkey is also invalid if the primary key of its entity is revoked or expired.
+// It returns when the key expires, the zero time if it never does.
+func ValidateSigningKey(entity *openpgp.Entity, now time.Time) (time.Time, error) {
+	if entity.PrivateKey == nil {
+		return time.Time{}, ErrSecretsNoPrivateKey
+	}
+	id := entity.PrivateKey.KeyId
+
+	if len(entity.Revocations) > 0 {
+		return time.Time{}, &KeyError{KeyID: id, Err: ErrSecretsKeyRevoked}
+	}
+
+	var (
+		sig     = primarySelfSignature(entity)
+		expires = keyExpiry(entity.PrimaryKey, sig)
+		key     = entity.PrimaryKey
+	)
+	if id != entity.PrimaryKey.KeyId {
+		sig = nil
+		for _, subkey := range entity.Subkeys {
+			if subkey.PublicKey.KeyId == id {
+				key, sig = subkey.PublicKey, subkey.Sig
+				break
+			}
+		}
+		if sig == nil {
+			return time.Time{}, &KeyError{KeyID: id, Err: ErrSecretsKeyNotFound}
+		}
+		if sig.SigType == packet.SigTypeSubkeyRevocation {
+			return time.Time{}, &KeyError{KeyID: id, Err: ErrSecretsKeyRevoked}
+		}
+		if subkeyExpires := keyExpiry(key, sig); expires.IsZero() || !subkeyExpires.IsZero() && subkeyExpires.Before(expires) {
+			expires = subkeyExpires
+		}
+	}
+
+	if !canSign(key, sig) {
+		return expires, &KeyError{KeyID: id, Err: ErrSecretsNotSigning}
+	}
+	if !expires.IsZero() && !now.Before(expires) {
+		return expires, &KeyError{KeyID: id, Err: ErrSecretsKeyExpired}
+	}
+	return expires, nil
+}
+
+// ValidatingProvider refuses keys that cannot sign, and warns when the key is about to expire.
+type ValidatingProvider struct {
+	GPGProvider
+	// Warning is how long before the key expires warnings are logged
+	Warning time.Duration
+	Logger  aws.Logger
+}
+
+// LoadPrivateKey loads the key from the wrapped provider and validates it.
+func (provider *ValidatingProvider) LoadPrivateKey(ctx context.Context) (*openpgp.Entity, error) {
+	entity, err := provider.GPGProvider.LoadPrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires, err := ValidateSigningKey(entity, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !expires.IsZero() && expires.Sub(now) < provider.Warning && provider.Logger != nil {
+		provider.Logger.Log(fmt.Sprintf("WARNING: signing key %016X expires in %s at %s, rotate it before signatures stop verifying",
+			entity.PrivateKey.KeyId, expires.Sub(now).Truncate(time.Minute), expires.UTC().Format(time.RFC3339)))
+	}
+	return entity, nil
+}
@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"testing"
+	"time"
+)
+
+// newTestEntity generates an entity whose primary key signs and whose subkey encrypts.
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// selfSignature returns the self-signature of the only identity of an entity.
+func selfSignature(entity *openpgp.Entity) *packet.Signature {
+	for _, ident := range entity.Identities {
+		return ident.SelfSignature
+	}
+	return nil
+}
+
+// signWithSubkey makes the subkey of an entity its signing key.
+func signWithSubkey(entity *openpgp.Entity) *openpgp.Subkey {
+	subkey := &entity.Subkeys[0]
+	subkey.Sig.FlagSign = true
+	entity.PrivateKey = subkey.PrivateKey
+	return subkey
+}
+
+func lifetime(d time.Duration) *uint32 {
+	secs := uint32(d / time.Second)
+	return &secs
+}
+
+func TestValidateSigningKey(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// setup changes the entity, returning the key lifetime
+		setup   func(entity *openpgp.Entity) time.Duration
+		wantErr error
+	}{
+		{"valid primary", func(entity *openpgp.Entity) time.Duration {
+			return 0
+		}, nil},
+		{"valid subkey", func(entity *openpgp.Entity) time.Duration {
+			signWithSubkey(entity)
+			return 0
+		}, nil},
+		{"primary not yet expired", func(entity *openpgp.Entity) time.Duration {
+			selfSignature(entity).KeyLifetimeSecs = lifetime(48 * time.Hour)
+			return 48 * time.Hour
+		}, nil},
+		{"expired primary", func(entity *openpgp.Entity) time.Duration {
+			selfSignature(entity).KeyLifetimeSecs = lifetime(time.Hour)
+			return time.Hour
+		}, ErrSecretsKeyExpired},
+		{"subkey of an expired primary", func(entity *openpgp.Entity) time.Duration {
+			signWithSubkey(entity)
+			selfSignature(entity).KeyLifetimeSecs = lifetime(time.Hour)
+			return time.Hour
+		}, ErrSecretsKeyExpired},
+		{"expired subkey of a valid primary", func(entity *openpgp.Entity) time.Duration {
+			signWithSubkey(entity).Sig.KeyLifetimeSecs = lifetime(time.Hour)
+			return time.Hour
+		}, ErrSecretsKeyExpired},
+		{"subkey expiring before its primary", func(entity *openpgp.Entity) time.Duration {
+			selfSignature(entity).KeyLifetimeSecs = lifetime(72 * time.Hour)
+			signWithSubkey(entity).Sig.KeyLifetimeSecs = lifetime(48 * time.Hour)
+			return 48 * time.Hour
+		}, nil},
+		{"revoked key", func(entity *openpgp.Entity) time.Duration {
+			entity.Revocations = append(entity.Revocations, &packet.Signature{SigType: packet.SigTypeKeyRevocation})
+			return 0
+		}, ErrSecretsKeyRevoked},
+		{"subkey of a revoked key", func(entity *openpgp.Entity) time.Duration {
+			signWithSubkey(entity)
+			entity.Revocations = append(entity.Revocations, &packet.Signature{SigType: packet.SigTypeKeyRevocation})
+			return 0
+		}, ErrSecretsKeyRevoked},
+		{"revoked subkey", func(entity *openpgp.Entity) time.Duration {
+			signWithSubkey(entity).Sig.SigType = packet.SigTypeSubkeyRevocation
+			return 0
+		}, ErrSecretsKeyRevoked},
+		{"primary without the sign flag", func(entity *openpgp.Entity) time.Duration {
+			selfSignature(entity).FlagSign = false
+			return 0
+		}, ErrSecretsNotSigning},
+		{"encryption subkey", func(entity *openpgp.Entity) time.Duration {
+			entity.PrivateKey = entity.Subkeys[0].PrivateKey
+			return 0
+		}, ErrSecretsNotSigning},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			entity := newTestEntity(t)
+			keyLifetime := c.setup(entity)
+			created := entity.PrivateKey.CreationTime
+			now := created.Add(24 * time.Hour)
+
+			expires, err := ValidateSigningKey(entity, now)
+			if c.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			if c.wantErr != nil {
+				keyErr, ok := err.(*KeyError)
+				if !ok || keyErr.Err != c.wantErr || keyErr.KeyID != entity.PrivateKey.KeyId {
+					t.Fatalf("got error %v, want %v of key %016X", err, c.wantErr, entity.PrivateKey.KeyId)
+				}
+			}
+
+			var want time.Time
+			if keyLifetime > 0 {
+				want = created.Add(keyLifetime)
+			}
+			if c.wantErr != ErrSecretsKeyRevoked && !expires.Equal(want) {
+				t.Errorf("expires %s, want %s", expires, want)
+			}
+		})
+	}
+}
+
+func TestValidateSigningKeyWithoutPrivateKey(t *testing.T) {
+	entity := newTestEntity(t)
+	entity.PrivateKey = nil
+	_, err := ValidateSigningKey(entity, time.Now())
+	if err != ErrSecretsNoPrivateKey {
+		t.Errorf("got %v, want %v", err, ErrSecretsNoPrivateKey)
+	}
+}
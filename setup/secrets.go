@@ -0,0 +1,76 @@
+package setup
+
+import (
+	"git.illumina.com/relvacode/rpm-lambda/secrets"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// EnvSigningKey is a URI of the signing key, see secrets.NewProvider, taking precedence over EnvSigningKeySecret
+	EnvSigningKey = `LAMBDA_SIGNING_KEY`
+	// EnvSigningKeySecret and EnvSigningKeyPassphraseSecret are the names of the AWS secrets of the signing key
+	EnvSigningKeySecret           = `LAMBDA_SECRET_GPG_KEY`
+	EnvSigningKeyPassphraseSecret = `LAMBDA_SECRET_GPG_PASSPHRASE`
+	// EnvSigningKeyID selects the signing key by key ID or fingerprint in a keyring with several keys or signing subkeys
+	EnvSigningKeyID = `LAMBDA_SIGNING_KEY_ID`
+	// EnvSigningKeyExpiryWarning is how long before the signing key expires warnings are logged
+	EnvSigningKeyExpiryWarning = `LAMBDA_SIGNING_KEY_EXPIRY_WARNING`
+	// EnvSigningKeyCacheTTL is how long the decrypted key is kept before its secrets are checked for a new version
+	EnvSigningKeyCacheTTL = `LAMBDA_SIGNING_KEY_CACHE_TTL`
+	// EnvSigningKeySecretFormat is the format of the private key secret, raw or json
+	EnvSigningKeySecretFormat = `LAMBDA_SECRET_GPG_FORMAT`
+	// EnvSigningKeyVersionStage pins the version stage of the secrets, e.g. AWSPENDING while the key is rotated
+	EnvSigningKeyVersionStage = `LAMBDA_SECRET_GPG_VERSION_STAGE`
+)
+
+// SigningKeyConfigured returns true if a signing key is configured.
+func SigningKeyConfigured() bool {
+	return GetEnv(EnvSigningKey, "") != "" || GetEnv(EnvSigningKeySecret, "") != ""
+}
+
+// NewKeyProvider returns a provider of the signing key located by EnvSigningKey, or held in AWS Secrets Manager.
+// The decrypted key is cached by warm lambda containers, and validated every time it is loaded.
+func NewKeyProvider(s *session.Session) (secrets.GPGProvider, error) {
+	id, err := secrets.ParseKeyID(GetEnv(EnvSigningKeyID, ""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", EnvSigningKeyID)
+	}
+
+	warning, err := time.ParseDuration(GetEnv(EnvSigningKeyExpiryWarning, "720h"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", EnvSigningKeyExpiryWarning)
+	}
+
+	ttl, err := time.ParseDuration(GetEnv(EnvSigningKeyCacheTTL, "5m"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", EnvSigningKeyCacheTTL)
+	}
+
+	var provider secrets.GPGProvider
+	if uri := GetEnv(EnvSigningKey, ""); uri != "" {
+		provider, err = secrets.NewProvider(uri, id, s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", EnvSigningKey)
+		}
+	} else {
+		amazon := secrets.NewAmazonKeyProvider(GetEnv(EnvSigningKeySecret), GetEnv(EnvSigningKeyPassphraseSecret, ""), s)
+		amazon.KeyID = id
+		amazon.VersionStage = GetEnv(EnvSigningKeyVersionStage, secrets.VersionStageCurrent)
+		amazon.Format = GetEnv(EnvSigningKeySecretFormat, secrets.SecretFormatRaw)
+		if amazon.Format != secrets.SecretFormatRaw && amazon.Format != secrets.SecretFormatJSON {
+			return nil, errors.Errorf("invalid %s %q", EnvSigningKeySecretFormat, amazon.Format)
+		}
+		provider = amazon
+	}
+
+	if ttl > 0 {
+		provider = &secrets.CachingProvider{GPGProvider: provider, TTL: ttl}
+	}
+	return &secrets.ValidatingProvider{
+		GPGProvider: provider,
+		Warning:     warning,
+		Logger:      NewLog("secrets:key"),
+	}, nil
+}
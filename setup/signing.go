@@ -0,0 +1,59 @@
+package setup
+
+import (
+	"crypto"
+	"git.illumina.com/relvacode/rpm-lambda/signing"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+const (
+	EnvSignatureDigest = `LAMBDA_SIGNATURE_DIGEST`
+	EnvSignatureTime   = `LAMBDA_SIGNATURE_TIME`
+	EnvSignatureTypes  = `LAMBDA_SIGNATURE_TYPES`
+	EnvSourceDateEpoch = `SOURCE_DATE_EPOCH`
+)
+
+// Signature types written to signed RPMs
+const (
+	// SignatureV4 only writes signatures over the header
+	SignatureV4 = "v4"
+	// SignatureV3V4 writes signatures over the header and over the header and payload
+	SignatureV3V4 = "v3+v4"
+)
+
+// signatureDigests are the supported signature digest algorithms
+var signatureDigests = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+// NewSigningOptions returns the options of RPM signatures configured by the environment.
+func NewSigningOptions() (signing.Options, error) {
+	var options signing.Options
+
+	digest := GetEnv(EnvSignatureDigest, "sha256")
+	options.Hash = signatureDigests[digest]
+	if options.Hash == 0 {
+		return options, errors.Errorf("invalid %s %q", EnvSignatureDigest, digest)
+	}
+
+	// SOURCE_DATE_EPOCH is used as a fixed creation time unless one is explicitly configured
+	if t := GetEnv(EnvSignatureTime, GetEnv(EnvSourceDateEpoch, "")); t != "" {
+		unix, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return options, errors.Wrapf(err, "invalid %s", EnvSignatureTime)
+		}
+		options.CreationTime = time.Unix(unix, 0)
+	}
+
+	switch types := GetEnv(EnvSignatureTypes, SignatureV3V4); types {
+	case SignatureV3V4:
+	case SignatureV4:
+		options.HeaderOnly = true
+	default:
+		return options, errors.Errorf("invalid %s %q", EnvSignatureTypes, types)
+	}
+	return options, nil
+}
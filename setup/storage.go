@@ -0,0 +1,48 @@
+package setup
+
+import (
+	"git.illumina.com/relvacode/rpm-lambda/storage"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const (
+	EnvS3ACL          = `LAMBDA_S3_ACL`
+	EnvS3SSE          = `LAMBDA_S3_SSE`
+	EnvS3SSEKMSKeyID  = `LAMBDA_S3_SSE_KMS_KEY_ID`
+	EnvS3StorageClass = `LAMBDA_S3_STORAGE_CLASS`
+	EnvS3CacheControl = `LAMBDA_S3_CACHE_CONTROL`
+
+	EnvS3CacheControlMutable   = `LAMBDA_S3_CACHE_CONTROL_MUTABLE`
+	EnvS3CacheControlImmutable = `LAMBDA_S3_CACHE_CONTROL_IMMUTABLE`
+)
+
+// NewS3 returns S3 storage with the options of uploaded objects read from the environment.
+// Uploaded objects are public-read unless another ACL is configured, an empty ACL leaves it to the bucket.
+func NewS3(s *session.Session) (*storage.S3, error) {
+	opts := storage.UploadOptions{
+		ACL:                  GetEnv(EnvS3ACL, s3.ObjectCannedACLPublicRead),
+		ServerSideEncryption: GetEnv(EnvS3SSE, ""),
+		KMSKeyID:             GetEnv(EnvS3SSEKMSKeyID, ""),
+		StorageClass:         GetEnv(EnvS3StorageClass, ""),
+		CacheControl:         GetEnv(EnvS3CacheControl, ""),
+	}
+	if opts.KMSKeyID != "" && opts.ServerSideEncryption == "" {
+		opts.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+	}
+
+	err := opts.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upload options")
+	}
+
+	return &storage.S3{
+		Session: s,
+		Options: opts,
+		Cache: storage.CachePolicy{
+			Mutable:   GetEnv(EnvS3CacheControlMutable, ""),
+			Immutable: GetEnv(EnvS3CacheControlImmutable, ""),
+		},
+	}, nil
+}
@@ -0,0 +1,179 @@
+package signing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+)
+
+const (
+	leadSize    = 96
+	headerMagic = 0x8eade801
+
+	// regionSignatures is the region tag of the signature header (RPMTAG_HEADERSIGNATURES)
+	regionSignatures = 62
+
+	// signature header tags of signatures over the header and payload
+	tagSigPGP = 1002
+	tagSigGPG = 1005
+)
+
+type headerIntro struct {
+	Magic, Reserved, Entries, Size uint32
+}
+
+type headerIndex struct {
+	Tag, Type, Offset, Count int32
+}
+
+var typeSizes = map[int32]int{
+	0: 0, // null
+	1: 1, // char
+	2: 1, // int8
+	3: 2, // int16
+	4: 4, // int32
+	5: 8, // int64
+	7: 1, // bin
+}
+
+var typeAlign = map[int32]int{
+	3: 2,
+	4: 4,
+	5: 8,
+}
+
+type headerEntry struct {
+	typ, count int32
+	data       []byte
+}
+
+// removeTags removes tags from a lead and signature header as dumped by rpmutils,
+// re-encoding the signature header the same way rpmutils writes it.
+func removeTags(dump []byte, tags ...int32) ([]byte, error) {
+	if len(dump) < leadSize+16 {
+		return nil, errors.New("signature header is truncated")
+	}
+
+	r := bytes.NewReader(dump[leadSize:])
+	var intro headerIntro
+	err := binary.Read(r, binary.BigEndian, &intro)
+	if err != nil {
+		return nil, err
+	}
+	if intro.Magic != headerMagic {
+		return nil, errors.New("bad signature header magic")
+	}
+
+	index := make([]headerIndex, intro.Entries)
+	err = binary.Read(r, binary.BigEndian, index)
+	if err != nil {
+		return nil, errors.Wrap(err, "signature header index is truncated")
+	}
+	data := make([]byte, intro.Size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "signature header data is truncated")
+	}
+
+	remove := make(map[int32]bool, len(tags))
+	for _, t := range tags {
+		remove[t] = true
+	}
+
+	entries := make(map[int32]headerEntry)
+	for _, ix := range index {
+		if ix.Tag == regionSignatures || remove[ix.Tag] {
+			continue
+		}
+		end, err := entryEnd(data, ix)
+		if err != nil {
+			return nil, err
+		}
+		entries[ix.Tag] = headerEntry{typ: ix.Type, count: ix.Count, data: data[ix.Offset:end]}
+	}
+
+	return writeSignatureHeader(dump[:leadSize], entries)
+}
+
+// entryEnd returns the end offset of the data of an index entry.
+func entryEnd(data []byte, ix headerIndex) (int, error) {
+	if ix.Offset < 0 || int(ix.Offset) > len(data) {
+		return 0, errors.Errorf("tag %d has an invalid offset", ix.Tag)
+	}
+
+	end := int(ix.Offset)
+	if size, ok := typeSizes[ix.Type]; ok {
+		end += size * int(ix.Count)
+	} else {
+		// strings are null-terminated
+		for i := 0; i < int(ix.Count); i++ {
+			next := bytes.IndexByte(data[end:], 0)
+			if next < 0 {
+				return 0, errors.Errorf("tag %d is truncated", ix.Tag)
+			}
+			end += next + 1
+		}
+	}
+	if end > len(data) {
+		return 0, errors.Errorf("tag %d is truncated", ix.Tag)
+	}
+	return end, nil
+}
+
+func writeSignatureHeader(lead []byte, entries map[int32]headerEntry) ([]byte, error) {
+	tags := make([]int, 0, len(entries))
+	for t := range entries {
+		tags = append(tags, int(t))
+	}
+	sort.Ints(tags)
+
+	var (
+		index = make([]headerIndex, 0, len(tags)+1)
+		blobs bytes.Buffer
+	)
+	for _, t := range tags {
+		e := entries[int32(t)]
+		if align, ok := typeAlign[e.typ]; ok && blobs.Len()%align != 0 {
+			blobs.Write(make([]byte, align-blobs.Len()%align))
+		}
+		index = append(index, headerIndex{Tag: int32(t), Type: e.typ, Offset: int32(blobs.Len()), Count: e.count})
+		blobs.Write(e.data)
+	}
+
+	// the region tag is the first index entry, its data at the end of the data points back to the start of the index
+	region := headerIndex{Tag: regionSignatures, Type: 7, Offset: int32(blobs.Len()), Count: 16}
+	err := binary.Write(&blobs, binary.BigEndian, &headerIndex{
+		Tag:    regionSignatures,
+		Type:   7,
+		Offset: int32(-16 * (len(tags) + 1)),
+		Count:  16,
+	})
+	if err != nil {
+		return nil, err
+	}
+	index = append([]headerIndex{region}, index...)
+
+	var out bytes.Buffer
+	out.Write(lead)
+	err = binary.Write(&out, binary.BigEndian, &headerIntro{
+		Magic:   headerMagic,
+		Entries: uint32(len(index)),
+		Size:    uint32(blobs.Len()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&out, binary.BigEndian, index)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(blobs.Bytes())
+
+	// the signature header is padded to 8 byte alignment
+	if n := out.Len() % 8; n != 0 {
+		out.Write(make([]byte, 8-n))
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,220 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/rustylynch/go-rpmutils"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testdata/simple-1.0.1-1.i386.rpm is an unsigned RPM from the go-rpmutils test data.
+const testRPM = "testdata/simple-1.0.1-1.i386.rpm"
+
+// signatureTypes are the options of each value of LAMBDA_SIGNATURE_TYPES
+var signatureTypes = []struct {
+	name string
+	opts Options
+}{
+	{"v3+v4", Options{}},
+	{"v4", Options{HeaderOnly: true}},
+}
+
+func newTestKey(t *testing.T) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test Signing", "", "signing@example.com", &packet.Config{
+		DefaultHash: crypto.SHA256,
+		RSABits:     2048,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// signTestRPM signs the test RPM, returning the signed RPM and its new signature header.
+func signTestRPM(t *testing.T, key *openpgp.Entity, opts Options) ([]byte, *Signed) {
+	t.Helper()
+	rpm, err := ioutil.ReadFile(testRPM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := Sign(bytes.NewReader(rpm), key.PrivateKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	_, err = signed.WriteTo(&out, bytes.NewReader(rpm[signed.Offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), signed
+}
+
+func TestSignatureTypes(t *testing.T) {
+	key := newTestKey(t)
+
+	for _, st := range signatureTypes {
+		st.opts.CreationTime = time.Unix(1500000000, 0)
+		rpm, _ := signTestRPM(t, key, st.opts)
+
+		hdr, err := rpmutils.ReadHeader(bytes.NewReader(rpm))
+		if err != nil {
+			t.Fatalf("%s: read signed header: %v", st.name, err)
+		}
+		if name, err := hdr.GetString(rpmutils.NAME); err != nil || name != "simple" {
+			t.Errorf("%s: package name %q, %v", st.name, name, err)
+		}
+		if !hdr.HasTag(rpmutils.SIG_RSA) {
+			t.Errorf("%s: no header signature", st.name)
+		}
+		if hdr.HasTag(rpmutils.SIG_PGP) == st.opts.HeaderOnly {
+			t.Errorf("%s: header and payload signature present: %t", st.name, hdr.HasTag(rpmutils.SIG_PGP))
+		}
+
+		sigs, err := HeaderSignatures(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sigs.SignedBy(key.PrimaryKey.KeyId) {
+			t.Errorf("%s: not signed by the signing key: %s", st.name, sigs)
+		}
+		for _, sig := range sigs {
+			if !sig.CreationTime.Equal(st.opts.CreationTime) {
+				t.Errorf("%s: signature created at %s, want %s", st.name, sig.CreationTime, st.opts.CreationTime)
+			}
+		}
+
+		err = Verify(bytes.NewReader(rpm), key)
+		if err != nil {
+			t.Errorf("%s: verify: %v", st.name, err)
+		}
+
+		// the payload is unchanged, so a corrupted payload must fail to verify
+		corrupt := append([]byte(nil), rpm...)
+		corrupt[len(corrupt)-1] ^= 0xff
+		if Verify(bytes.NewReader(corrupt), key) == nil {
+			t.Errorf("%s: corrupted payload verifies", st.name)
+		}
+	}
+}
+
+func TestRemoveTags(t *testing.T) {
+	key := newTestKey(t)
+	_, signed := signTestRPM(t, key, Options{})
+
+	// without tags to remove the header is re-encoded exactly as rpmutils writes it
+	same, err := removeTags(signed.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(same, signed.Header) {
+		t.Fatalf("re-encoded signature header differs from rpmutils:\n%x\n%x", same, signed.Header)
+	}
+
+	headerOnly, err := removeTags(signed.Header, tagSigPGP, tagSigGPG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headerOnly)%8 != 0 {
+		t.Errorf("signature header of %d bytes is not padded", len(headerOnly))
+	}
+
+	rpm, err := ioutil.ReadFile(testRPM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append(headerOnly, rpm[signed.Offset:]...)
+
+	original, err := rpmutils.ReadHeader(bytes.NewReader(append(append([]byte(nil), signed.Header...), rpm[signed.Offset:]...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := rpmutils.ReadHeader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("read header with removed tags: %v", err)
+	}
+	if hdr.HasTag(rpmutils.SIG_PGP) || hdr.HasTag(rpmutils.SIG_GPG) {
+		t.Error("header and payload signature was not removed")
+	}
+
+	// every other tag is kept as it was
+	for _, tag := range []int{rpmutils.SIG_RSA, rpmutils.SIG_SHA1, rpmutils.SIG_MD5, rpmutils.SIG_SIZE} {
+		if !original.HasTag(tag) {
+			continue
+		}
+		want, err := original.Get(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := hdr.Get(tag)
+		if err != nil {
+			t.Errorf("tag %d: %v", tag, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tag %d changed: %v, want %v", tag, got, want)
+		}
+	}
+
+	_, _, err = rpmutils.Verify(bytes.NewReader(out), openpgp.EntityList{key})
+	if err != nil {
+		t.Errorf("verify: %v", err)
+	}
+}
+
+func TestRemoveTagsRPMBuildHeader(t *testing.T) {
+	rpm, err := ioutil.ReadFile(testRPM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := rpmutils.ReadHeader(bytes.NewReader(rpm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := original.OriginalSignatureHeaderSize()
+
+	// the signature header written by rpmbuild is re-encoded without its MD5 digest, tag 1004 of the signature header
+	dump, err := removeTags(rpm[:offset], 1004)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append(dump, rpm[offset:]...)
+	hdr, err := rpmutils.ReadHeader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("read re-encoded header: %v", err)
+	}
+	if hdr.HasTag(rpmutils.SIG_MD5) {
+		t.Error("MD5 digest was not removed")
+	}
+	for _, tag := range []int{rpmutils.SIG_SHA1, rpmutils.SIG_SIZE, rpmutils.NAME} {
+		want, err := original.Get(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := hdr.Get(tag)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("tag %d: %v, %v, want %v", tag, got, err, want)
+		}
+	}
+
+	// the re-encoded header is signed as any other
+	key := newTestKey(t)
+	for _, st := range signatureTypes {
+		signed, err := Sign(bytes.NewReader(out), key.PrivateKey, st.opts)
+		if err != nil {
+			t.Fatalf("%s: sign: %v", st.name, err)
+		}
+		err = Verify(io.MultiReader(bytes.NewReader(signed.Header), bytes.NewReader(out[signed.Offset:])), key)
+		if err != nil {
+			t.Errorf("%s: verify: %v", st.name, err)
+		}
+	}
+}
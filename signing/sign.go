@@ -0,0 +1,151 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/pkg/errors"
+	"github.com/rustylynch/go-rpmutils"
+	"golang.org/x/crypto/openpgp/packet"
+	"hash"
+	"io"
+	"time"
+)
+
+// Options control the signatures added to an RPM.
+type Options struct {
+	// Hash is the digest algorithm of the signatures, SHA256 if zero
+	Hash crypto.Hash
+	// CreationTime of the signatures, the current time if zero
+	CreationTime time.Time
+	// HeaderOnly only writes signatures over the header (v4 signatures) and omits signatures over the header
+	// and payload (v3 signatures), as preferred by rpm 4.16 and later.
+	HeaderOnly bool
+}
+
+func (opts Options) signatureOptions() *rpmutils.SignatureOptions {
+	o := &rpmutils.SignatureOptions{
+		Hash:         opts.Hash,
+		CreationTime: opts.CreationTime,
+	}
+	if o.Hash == 0 {
+		o.Hash = crypto.SHA256
+	}
+	if o.CreationTime.IsZero() {
+		o.CreationTime = time.Now()
+	}
+	return o
+}
+
+// Signed is the signed lead and signature header of an RPM.
+type Signed struct {
+	// Header is the new lead and signature header
+	Header []byte
+	// Offset is the offset of the general header in the original RPM.
+	// The signed RPM is Header followed by the original RPM from Offset.
+	Offset int64
+}
+
+// WriteTo writes the signed RPM to w given a reader of the original RPM positioned at Offset.
+func (s *Signed) WriteTo(w io.Writer, rest io.Reader) (int64, error) {
+	n, err := w.Write(s.Header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := io.Copy(w, rest)
+	return int64(n) + m, err
+}
+
+// Sign reads a complete RPM and creates its signatures with key.
+// Only the lead, signature header and general header are kept in memory, the payload is streamed.
+// The new signature header is read back and its signatures verified before it is returned.
+func Sign(r io.Reader, key *packet.PrivateKey, opts Options) (*Signed, error) {
+	sigOpts := opts.signatureOptions()
+
+	// buffer the lead and headers, needed again to verify the signatures
+	var head bytes.Buffer
+	original, err := rpmutils.ReadHeader(io.TeeReader(r, &head))
+	if err != nil {
+		return nil, errors.Wrap(err, "read RPM header")
+	}
+	offset := original.OriginalSignatureHeaderSize()
+	genHeader := head.Bytes()[offset:]
+
+	// digest the header and payload alongside rpmutils to verify the payload signature
+	payload := sigOpts.Hash.New()
+	_, _ = payload.Write(genHeader)
+
+	header, err := rpmutils.SignRpmStream(io.MultiReader(bytes.NewReader(head.Bytes()), io.TeeReader(r, payload)), key, sigOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign RPM")
+	}
+
+	dump, err := header.DumpSignatureHeader(false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HeaderOnly {
+		dump, err = removeTags(dump, tagSigPGP, tagSigGPG)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = verifyHeader(dump, genHeader, payload, key, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify signed header")
+	}
+
+	return &Signed{
+		Header: dump,
+		Offset: int64(offset),
+	}, nil
+}
+
+// verifyHeader re-reads a signed signature header, checking that it holds the expected signatures
+// and that they verify against the general header and the digest of the header and payload.
+func verifyHeader(dump, genHeader []byte, payload hash.Hash, key *packet.PrivateKey, opts Options) error {
+	signed, err := rpmutils.ReadHeader(io.MultiReader(bytes.NewReader(dump), bytes.NewReader(genHeader)))
+	if err != nil {
+		return err
+	}
+
+	var found int
+	for _, t := range signatureTags {
+		blob, err := signed.GetBytes(t.tag)
+		if _, ok := err.(rpmutils.NoSuchTagError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		p, err := packet.NewReader(bytes.NewReader(blob)).Next()
+		if err != nil {
+			return err
+		}
+		sig, ok := p.(*packet.Signature)
+		if !ok || sig.IssuerKeyId == nil || *sig.IssuerKeyId != key.KeyId {
+			return errors.Errorf("signature tag %d was not made by the signing key", t.tag)
+		}
+
+		h := payload
+		if t.headerOnly {
+			h = sig.Hash.New()
+			_, _ = h.Write(genHeader)
+		}
+		err = key.PublicKey.VerifySignature(h, sig)
+		if err != nil {
+			return errors.Wrapf(err, "signature tag %d", t.tag)
+		}
+		found++
+	}
+
+	want := 2
+	if opts.HeaderOnly {
+		want = 1
+	}
+	if found != want {
+		return errors.Errorf("expected %d signatures, found %d", want, found)
+	}
+	return nil
+}
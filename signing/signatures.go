@@ -0,0 +1,123 @@
+// Package signing signs RPM packages and inspects their existing signatures.
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rustylynch/go-rpmutils"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"strings"
+	"time"
+)
+
+// Signature is an OpenPGP signature found in the signature header of an RPM.
+type Signature struct {
+	KeyID        uint64
+	Hash         crypto.Hash
+	CreationTime time.Time
+	// HeaderOnly is true for signatures over the header, false for signatures over the header and payload
+	HeaderOnly bool
+}
+
+// signatureTags are the signature header tags holding OpenPGP signatures
+var signatureTags = []struct {
+	tag        int
+	headerOnly bool
+}{
+	{rpmutils.SIG_RSA, true},
+	{rpmutils.SIG_DSA, true},
+	{rpmutils.SIG_PGP, false},
+	{rpmutils.SIG_GPG, false},
+}
+
+// KeyID formats an OpenPGP key ID the way gpg and rpm display long key IDs.
+func KeyID(id uint64) string {
+	return fmt.Sprintf("%016X", id)
+}
+
+// Signatures are the signatures of an RPM.
+type Signatures []Signature
+
+// SignedBy returns true if the RPM is signed and every signature was made by the given key.
+func (sigs Signatures) SignedBy(keyID uint64) bool {
+	for _, s := range sigs {
+		if s.KeyID != keyID {
+			return false
+		}
+	}
+	return len(sigs) > 0
+}
+
+// String returns the distinct signer key IDs of the signatures.
+func (sigs Signatures) String() string {
+	var (
+		ids  []string
+		seen = make(map[uint64]bool)
+	)
+	for _, s := range sigs {
+		if !seen[s.KeyID] {
+			seen[s.KeyID] = true
+			ids = append(ids, KeyID(s.KeyID))
+		}
+	}
+	return strings.Join(ids, ", ")
+}
+
+// ReadSignatures reads the signatures of an RPM from its lead and headers without reading the payload.
+func ReadSignatures(r io.Reader) (Signatures, error) {
+	hdr, err := rpmutils.ReadHeader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read RPM header")
+	}
+	return HeaderSignatures(hdr)
+}
+
+// HeaderSignatures returns the signatures in the signature header of an RPM.
+func HeaderSignatures(hdr *rpmutils.RpmHeader) (Signatures, error) {
+	var sigs Signatures
+	for _, t := range signatureTags {
+		blob, err := hdr.GetBytes(t.tag)
+		if _, ok := err.(rpmutils.NoSuchTagError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sig, err := parseSignature(blob)
+		if err != nil {
+			return nil, errors.Wrapf(err, "signature tag %d", t.tag)
+		}
+		sig.HeaderOnly = t.headerOnly
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+func parseSignature(blob []byte) (Signature, error) {
+	p, err := packet.NewReader(bytes.NewReader(blob)).Next()
+	if err != nil {
+		return Signature{}, err
+	}
+
+	switch sig := p.(type) {
+	case *packet.SignatureV3:
+		return Signature{KeyID: sig.IssuerKeyId, Hash: sig.Hash, CreationTime: sig.CreationTime}, nil
+	case *packet.Signature:
+		if sig.IssuerKeyId == nil {
+			return Signature{}, errors.New("no issuer key ID")
+		}
+		return Signature{KeyID: *sig.IssuerKeyId, Hash: sig.Hash, CreationTime: sig.CreationTime}, nil
+	}
+	return Signature{}, errors.New("not an OpenPGP signature")
+}
+
+// Verify reads a complete RPM and verifies all of its signatures against key.
+func Verify(r io.Reader, key *openpgp.Entity) error {
+	_, _, err := rpmutils.Verify(r, openpgp.EntityList{key})
+	return err
+}
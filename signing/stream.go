@@ -0,0 +1,47 @@
+package signing
+
+import (
+	"bytes"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+)
+
+// Opener opens an RPM for reading from offset.
+// Every call must read the same version of the RPM.
+type Opener func(offset int64) (io.ReadCloser, error)
+
+// SignStream signs an RPM, returning the signed RPM.
+// The RPM is read once to create its signatures and then streamed again from after its original signature header,
+// so that only its headers are kept in memory.
+func SignStream(key *packet.PrivateKey, open Opener, opts Options) (io.ReadCloser, error) {
+	r, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return SignReader(key, r, open, opts)
+}
+
+// SignReader signs the RPM read from r like SignStream, for callers that have already started reading the RPM.
+// open is only used to stream the RPM again from after its original signature header.
+func SignReader(key *packet.PrivateKey, r io.Reader, open Opener, opts Options) (io.ReadCloser, error) {
+	signed, err := Sign(r, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rest, err := open(signed.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &signedReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(signed.Header), rest),
+		Closer: rest,
+	}, nil
+}
+
+type signedReadCloser struct {
+	io.Reader
+	io.Closer
+}
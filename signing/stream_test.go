@@ -0,0 +1,51 @@
+package signing
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSignReader(t *testing.T) {
+	key := newTestKey(t)
+	rpm, err := ioutil.ReadFile(testRPM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opened []int64
+	open := func(offset int64) (io.ReadCloser, error) {
+		opened = append(opened, offset)
+		return ioutil.NopCloser(bytes.NewReader(rpm[offset:])), nil
+	}
+
+	// the signatures are read from the start of the download that is then signed
+	r, _ := open(0)
+	var head bytes.Buffer
+	sigs, err := ReadSignatures(io.TeeReader(r, &head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 0 {
+		t.Fatalf("test RPM is signed by %s", sigs)
+	}
+
+	body, err := SignReader(key.PrivateKey, io.MultiReader(&head, r), open, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := ioutil.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opened) != 2 || opened[1] == 0 {
+		t.Errorf("RPM opened at %v, want once in full and once after its signature header", opened)
+	}
+	err = Verify(bytes.NewReader(signed), key)
+	if err != nil {
+		t.Errorf("verify: %v", err)
+	}
+}
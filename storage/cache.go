@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"git.illumina.com/relvacode/rpm-lambda/yum"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+// scanCacheVersion is part of every cache key so that entries written by an incompatible version of yum.RPM are ignored.
+const scanCacheVersion = "v3"
+
+// ObjectVersion identifies a specific revision of an object in S3.
+type ObjectVersion struct {
+	Bucket    string
+	Key       string
+	ETag      string
+	VersionID string
+}
+
+// ID returns the version ID of the object if known, otherwise its ETag.
+func (v ObjectVersion) ID() string {
+	if v.VersionID != "" {
+		return v.VersionID
+	}
+	return strings.Trim(v.ETag, `"`)
+}
+
+// ScanCache stores the results of scanning RPM objects as JSON documents under Prefix,
+// keyed by the bucket, key and version of each object.
+// Cache objects are private whatever the ACL of the storage, so that they are not published with the repository.
+type ScanCache struct {
+	S3 *S3
+	// Bucket to store the cache in, if empty the cache is stored in the same bucket as the object.
+	Bucket string
+	Prefix string
+}
+
+func (c *ScanCache) location(v ObjectVersion) (string, string) {
+	bucket := c.Bucket
+	if bucket == "" {
+		bucket = v.Bucket
+	}
+	return bucket, path.Join(c.Prefix, scanCacheVersion, v.Bucket, v.Key, fmt.Sprintf("%s.json", v.ID()))
+}
+
+// Get returns the cached scan of a version of an object.
+// Returns false if the object has not been scanned at this version.
+func (c *ScanCache) Get(ctx context.Context, v ObjectVersion) (*yum.RPM, bool, error) {
+	if v.ID() == "" {
+		return nil, false, nil
+	}
+
+	bucket, key := c.location(v)
+	found, r, err := c.S3.DownloadObject(ctx, bucket, key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	defer r.Close()
+
+	var rpm yum.RPM
+	err = json.NewDecoder(r).Decode(&rpm)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to decode scan cache %q", key)
+	}
+
+	return &rpm, true, nil
+}
+
+// Put stores the scan of a version of an object in the cache.
+func (c *ScanCache) Put(ctx context.Context, v ObjectVersion, rpm *yum.RPM) error {
+	if v.ID() == "" {
+		return nil
+	}
+
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(rpm)
+	if err != nil {
+		return err
+	}
+
+	bucket, key := c.location(v)
+	return c.S3.UploadObjectWithOptions(ctx, &b, bucket, key, "application/json", UploadOptions{ACL: s3.ObjectCannedACLPrivate})
+}
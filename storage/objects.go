@@ -2,7 +2,6 @@ package storage
 
 import (
 	"git.illumina.com/relvacode/rpm-lambda/yum"
-	"time"
 )
 
 const (
@@ -11,25 +10,32 @@ const (
 	FilelistXML = "repodata/filelists.xml.gz"
 )
 
+// MetadataSignedETag is the user metadata of a signature holding the ETag of the object it signs
+const MetadataSignedETag = "signed-etag"
+
 type XMLObject struct {
 	Key             string
 	ObjectChecksum  yum.Checksum
 	ContentChecksum yum.Checksum
+	ObjectSize      int64
+	ContentSize     int64
 }
 
 type FilelistXMLObject struct {
 	XMLObject
 }
 
-func (o FilelistXMLObject) Metadata() yum.Metadata {
+func (o FilelistXMLObject) Metadata(timestamp int64) yum.Metadata {
 	return yum.Metadata{
 		Type: "filelists",
 		Location: yum.Location{
 			Href: o.XMLObject.Key,
 		},
-		Timestamp:       time.Now().Unix(),
+		Timestamp:       timestamp,
 		Checksum:        o.XMLObject.ObjectChecksum,
-		ContentChecksum: o.XMLObject.ContentChecksum,
+		ContentChecksum: &o.XMLObject.ContentChecksum,
+		Size:            o.XMLObject.ObjectSize,
+		ContentSize:     o.XMLObject.ContentSize,
 	}
 }
 
@@ -37,14 +43,16 @@ type PrimaryXMLObject struct {
 	XMLObject
 }
 
-func (p PrimaryXMLObject) Metadata() yum.Metadata {
+func (p PrimaryXMLObject) Metadata(timestamp int64) yum.Metadata {
 	return yum.Metadata{
 		Type: "primary",
 		Location: yum.Location{
 			Href: p.XMLObject.Key,
 		},
-		Timestamp:       time.Now().Unix(),
+		Timestamp:       timestamp,
 		Checksum:        p.XMLObject.ObjectChecksum,
-		ContentChecksum: p.XMLObject.ContentChecksum,
+		ContentChecksum: &p.XMLObject.ContentChecksum,
+		Size:            p.XMLObject.ObjectSize,
+		ContentSize:     p.XMLObject.ContentSize,
 	}
 }
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+// UploadOptions control how uploaded objects are stored.
+// Empty options are left out of the upload so that the defaults of the bucket apply.
+type UploadOptions struct {
+	// ACL is a canned ACL such as public-read or private
+	ACL string
+	// ServerSideEncryption is either AES256 or aws:kms
+	ServerSideEncryption string
+	// KMSKeyID is the KMS key used with aws:kms encryption, the AWS managed key is used if empty
+	KMSKeyID     string
+	StorageClass string
+	CacheControl string
+	// Metadata is the user metadata of the object
+	Metadata map[string]*string
+	// Tagging is a URL query encoded tag set, e.g. "product=example&stage=release"
+	Tagging string
+}
+
+// Validate returns an error if the ACL or encryption are not recognised.
+func (o UploadOptions) Validate() error {
+	switch o.ACL {
+	case "",
+		s3.ObjectCannedACLPrivate,
+		s3.ObjectCannedACLPublicRead,
+		s3.ObjectCannedACLPublicReadWrite,
+		s3.ObjectCannedACLAuthenticatedRead,
+		s3.ObjectCannedACLAwsExecRead,
+		s3.ObjectCannedACLBucketOwnerRead,
+		s3.ObjectCannedACLBucketOwnerFullControl:
+	default:
+		return errors.Errorf("unknown ACL %q", o.ACL)
+	}
+
+	switch o.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256:
+		if o.KMSKeyID != "" {
+			return errors.New("a KMS key ID requires aws:kms encryption")
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return errors.Errorf("unknown server-side encryption %q", o.ServerSideEncryption)
+	}
+	return nil
+}
+
+// With returns the options overridden by the non-empty options of opts.
+func (o UploadOptions) With(opts UploadOptions) UploadOptions {
+	override := func(v *string, with string) {
+		if with != "" {
+			*v = with
+		}
+	}
+
+	override(&o.ACL, opts.ACL)
+	if opts.ServerSideEncryption != "" {
+		o.ServerSideEncryption = opts.ServerSideEncryption
+		o.KMSKeyID = opts.KMSKeyID
+	}
+	override(&o.StorageClass, opts.StorageClass)
+	override(&o.CacheControl, opts.CacheControl)
+	override(&o.Tagging, opts.Tagging)
+	if opts.Metadata != nil {
+		o.Metadata = opts.Metadata
+	}
+	return o
+}
+
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+func (o UploadOptions) uploadInput(bucket, key, content string) *s3manager.UploadInput {
+	return &s3manager.UploadInput{
+		ACL:                  optionalString(o.ACL),
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ContentType:          aws.String(content),
+		CacheControl:         optionalString(o.CacheControl),
+		ServerSideEncryption: optionalString(o.ServerSideEncryption),
+		SSEKMSKeyId:          optionalString(o.KMSKeyID),
+		StorageClass:         optionalString(o.StorageClass),
+		Metadata:             o.Metadata,
+		Tagging:              optionalString(o.Tagging),
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// CacheControlNoCache makes caches revalidate an object before every use
+	CacheControlNoCache = "no-cache"
+	// CacheControlImmutable lets caches keep an object for a year without revalidating it
+	CacheControlImmutable = "public, max-age=31536000, immutable"
+)
+
+// checksumNamed matches repository data files prefixed by the checksum of their content, e.g. "<sha256>-primary.xml.gz"
+var checksumNamed = regexp.MustCompile(`^[0-9a-f]{32,128}-`)
+
+// contentTypes of repository data files by extension.
+// Compressed data is served as-is without a Content-Encoding so that clients checksum the compressed object.
+var contentTypes = map[string]string{
+	".xml":    "text/xml",
+	".gz":     "application/x-gzip",
+	".bz2":    "application/x-bzip2",
+	".xz":     "application/x-xz",
+	".zck":    "application/zchunk",
+	".sqlite": "application/vnd.sqlite3",
+	".asc":    "application/pgp-signature",
+	".sig":    "application/pgp-signature",
+	".rpm":    "application/x-rpm",
+}
+
+// ObjectPolicy is the Content-Type and Cache-Control of a class of objects.
+type ObjectPolicy struct {
+	ContentType  string
+	CacheControl string
+}
+
+// CachePolicy is the Cache-Control of mutable and immutable repository objects.
+// Empty values default to CacheControlNoCache and CacheControlImmutable.
+type CachePolicy struct {
+	Mutable   string
+	Immutable string
+	// Private are the prefixes of objects stored alongside a repository that are never part of it, such as checkpoints
+	Private []string
+}
+
+// MutableCacheControl returns the Cache-Control of objects that are replaced in place.
+func (c CachePolicy) MutableCacheControl() string {
+	if c.Mutable == "" {
+		return CacheControlNoCache
+	}
+	return c.Mutable
+}
+
+// ImmutableCacheControl returns the Cache-Control of objects that never change once published.
+func (c CachePolicy) ImmutableCacheControl() string {
+	if c.Immutable == "" {
+		return CacheControlImmutable
+	}
+	return c.Immutable
+}
+
+// For returns the policy of an object by its key, or false if the object is not part of a repository.
+//
+// Only checksum-named data files never change once published and are immutable.
+// repomd.xml, signatures, clear-signed copies and data files that are replaced in place are mutable, and so are RPMs,
+// which are overwritten when a package is replaced or re-signed.
+func (c CachePolicy) For(key string) (ObjectPolicy, bool) {
+	for _, prefix := range c.Private {
+		if strings.HasPrefix(key, strings.TrimSuffix(prefix, "/")+"/") {
+			return ObjectPolicy{}, false
+		}
+	}
+
+	var (
+		name        = path.Base(key)
+		contentType = contentTypes[path.Ext(name)]
+	)
+
+	repodata := path.Base(path.Dir(key)) == "repodata"
+
+	switch {
+	case strings.HasSuffix(name, ".asc"), strings.HasSuffix(name, ".sig"), strings.HasSuffix(name, ".rpm"):
+		return ObjectPolicy{ContentType: contentType, CacheControl: c.MutableCacheControl()}, true
+	case name == "InRelease", strings.HasSuffix(name, ".clearsigned"):
+		// clear-signed copies
+		return ObjectPolicy{ContentType: "text/plain; charset=utf-8", CacheControl: c.MutableCacheControl()}, true
+	case !repodata || contentType == "":
+		return ObjectPolicy{}, false
+	case name != "repomd.xml" && checksumNamed.MatchString(name):
+		return ObjectPolicy{ContentType: contentType, CacheControl: c.ImmutableCacheControl()}, true
+	}
+	return ObjectPolicy{ContentType: contentType, CacheControl: c.MutableCacheControl()}, true
+}
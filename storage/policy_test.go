@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestCachePolicyFor(t *testing.T) {
+	const sha = "0bd4b5b5a9b8e5f3c1d4e2c6a7f8091a2b3c4d5e6f708192a3b4c5d6e7f80912"
+
+	policy := CachePolicy{Private: []string{".resign-checkpoints"}}
+	for _, c := range []struct {
+		key  string
+		want ObjectPolicy
+		ok   bool
+	}{
+		{"repodata/repomd.xml", ObjectPolicy{"text/xml", CacheControlNoCache}, true},
+		{"el7/x86_64/repodata/repomd.xml", ObjectPolicy{"text/xml", CacheControlNoCache}, true},
+		{"repodata/" + sha + "-primary.xml.gz", ObjectPolicy{"application/x-gzip", CacheControlImmutable}, true},
+		{"repodata/" + sha + "-primary.sqlite.bz2", ObjectPolicy{"application/x-bzip2", CacheControlImmutable}, true},
+		{"repodata/" + sha + "-primary.xml.zck", ObjectPolicy{"application/zchunk", CacheControlImmutable}, true},
+		{"repodata/primary.xml.gz", ObjectPolicy{"application/x-gzip", CacheControlNoCache}, true},
+		{"repodata/filelists.xml.gz", ObjectPolicy{"application/x-gzip", CacheControlNoCache}, true},
+		{"repodata/abc-primary.xml.gz", ObjectPolicy{"application/x-gzip", CacheControlNoCache}, true},
+		{"el7/x86_64/a-1.0-1.x86_64.rpm", ObjectPolicy{"application/x-rpm", CacheControlNoCache}, true},
+		{"repodata/repomd.xml.asc", ObjectPolicy{"application/pgp-signature", CacheControlNoCache}, true},
+		{"repodata/repomd.xml.sig", ObjectPolicy{"application/pgp-signature", CacheControlNoCache}, true},
+		{"repodata/repomd.xml.clearsigned", ObjectPolicy{"text/plain; charset=utf-8", CacheControlNoCache}, true},
+		{"dists/stable/InRelease", ObjectPolicy{"text/plain; charset=utf-8", CacheControlNoCache}, true},
+		{"repodata/comps.txt", ObjectPolicy{}, false},
+		{"index.html", ObjectPolicy{}, false},
+		{"primary.xml.gz", ObjectPolicy{}, false},
+		{".resign-checkpoints/repo/el7/repodata/checkpoint.json", ObjectPolicy{}, false},
+		{".resign-checkpoints/repo/el7/a-1.0-1.x86_64.rpm", ObjectPolicy{}, false},
+		{".resign-checkpoints-old/a-1.0-1.x86_64.rpm", ObjectPolicy{"application/x-rpm", CacheControlNoCache}, true},
+	} {
+		got, ok := policy.For(c.key)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: got %+v %v, want %+v %v", c.key, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCachePolicyOverrides(t *testing.T) {
+	policy := CachePolicy{Mutable: "max-age=60", Immutable: "max-age=86400"}
+
+	got, _ := policy.For("repodata/repomd.xml")
+	if got.CacheControl != "max-age=60" {
+		t.Errorf("repomd.xml: got %s", got.CacheControl)
+	}
+	got, _ = policy.For("repodata/0bd4b5b5a9b8e5f3c1d4e2c6a7f8091a-primary.xml.gz")
+	if got.CacheControl != "max-age=86400" {
+		t.Errorf("checksum-named data: got %s", got.CacheControl)
+	}
+}
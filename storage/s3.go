@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/xml"
+	"fmt"
 	"git.illumina.com/relvacode/rpm-lambda/yum"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -12,6 +14,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
 	"io"
+	"net/http"
+	"net/url"
+	"strings"
 )
 
 func simpleConcurrentError(f func() error) chan error {
@@ -23,8 +28,20 @@ func simpleConcurrentError(f func() error) chan error {
 	return err
 }
 
+// byteCounter counts the number of bytes written to it
+type byteCounter int64
+
+func (bc *byteCounter) Write(b []byte) (int, error) {
+	*bc += byteCounter(len(b))
+	return len(b), nil
+}
+
 type S3 struct {
 	*session.Session
+	// Options are applied to every uploaded object
+	Options UploadOptions
+	// Cache sets the Content-Type and Cache-Control of repository objects
+	Cache CachePolicy
 }
 
 func (storage *S3) DeleteObject(ctx context.Context, bucket, key string) error {
@@ -35,6 +52,36 @@ func (storage *S3) DeleteObject(ctx context.Context, bucket, key string) error {
 	return err
 }
 
+// CopyOptions control how a copy of an object is stored.
+type CopyOptions struct {
+	// StorageClass of the copy, the default storage class of the bucket is used if empty
+	StorageClass string
+	// Tagging replaces the tags of the copy with a URL query encoded tag set, e.g. "retention=audit&expire=365"
+	Tagging string
+}
+
+// CopyObject copies an object to another key, which may be in a different bucket.
+func (storage *S3) CopyObject(ctx context.Context, bucket, key, targetBucket, targetKey string, opts CopyOptions) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(targetBucket),
+		Key:        aws.String(targetKey),
+		CopySource: aws.String(url.PathEscape(bucket + "/" + key)),
+	}
+	if opts.StorageClass != "" {
+		input.StorageClass = aws.String(opts.StorageClass)
+	}
+	if opts.Tagging != "" {
+		input.Tagging = aws.String(opts.Tagging)
+		input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+	}
+	// copies are encrypted the same way as uploads
+	input.ServerSideEncryption = optionalString(storage.Options.ServerSideEncryption)
+	input.SSEKMSKeyId = optionalString(storage.Options.KMSKeyID)
+
+	_, err := s3.New(storage).CopyObjectWithContext(ctx, input)
+	return errors.Wrap(err, "copy object")
+}
+
 func (storage *S3) DownloadObject(ctx context.Context, bucket, key string) (bool, io.ReadCloser, error) {
 	o, err := s3.New(storage).GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: &bucket,
@@ -52,6 +99,129 @@ func (storage *S3) DownloadObject(ctx context.Context, bucket, key string) (bool
 	return true, o.Body, nil
 }
 
+// DownloadObjectVersion downloads a version of an object from offset to the end of the object.
+// The download fails if the object has been replaced since the version was taken.
+func (storage *S3) DownloadObjectVersion(ctx context.Context, v ObjectVersion, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(v.Bucket),
+		Key:    aws.String(v.Key),
+	}
+	if v.VersionID != "" {
+		input.VersionId = aws.String(v.VersionID)
+	} else if v.ETag != "" {
+		input.IfMatch = aws.String(v.ETag)
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	o, err := s3.New(storage).GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.Wrap(err, "download object version")
+	}
+	return o.Body, nil
+}
+
+// StatObject returns the current version of an object without downloading it.
+func (storage *S3) StatObject(ctx context.Context, bucket, key string) (*ObjectVersion, error) {
+	o, err := s3.New(storage).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "stat object")
+	}
+
+	return &ObjectVersion{
+		Bucket:    bucket,
+		Key:       key,
+		ETag:      aws.StringValue(o.ETag),
+		VersionID: aws.StringValue(o.VersionId),
+	}, nil
+}
+
+// WalkObjects calls fn with the key of every object under prefix in bucket in lexical order, starting after startAfter,
+// until fn returns false or an error.
+func (storage *S3) WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(key string) (bool, error)) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: optionalString(prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+
+	var walkErr error
+	err := s3.New(storage).ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			var next bool
+			next, walkErr = fn(aws.StringValue(o.Key))
+			if walkErr != nil || !next {
+				return false
+			}
+		}
+		return true
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	return errors.Wrap(err, "list objects")
+}
+
+// UserMetadata returns the user metadata of an object with lower-case keys, or false if the object does not exist.
+func (storage *S3) UserMetadata(ctx context.Context, bucket, key string) (map[string]string, bool, error) {
+	head, err := s3.New(storage).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if ex, ok := err.(awserr.RequestFailure); ok && ex.StatusCode() == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrap(err, "user metadata")
+	}
+
+	meta := make(map[string]string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		meta[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return meta, true, nil
+}
+
+// ObjectMetadata returns the user metadata and tags of a version of an object
+// as options that copy them onto an uploaded object.
+func (storage *S3) ObjectMetadata(ctx context.Context, v ObjectVersion) (UploadOptions, error) {
+	svc := s3.New(storage)
+
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(v.Bucket),
+		Key:       aws.String(v.Key),
+		VersionId: optionalString(v.VersionID),
+	})
+	if err != nil {
+		return UploadOptions{}, errors.Wrap(err, "object metadata")
+	}
+
+	tagging, err := svc.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(v.Bucket),
+		Key:       aws.String(v.Key),
+		VersionId: optionalString(v.VersionID),
+	})
+	if err != nil {
+		return UploadOptions{}, errors.Wrap(err, "object tags")
+	}
+
+	tags := make(url.Values)
+	for _, t := range tagging.TagSet {
+		tags.Add(aws.StringValue(t.Key), aws.StringValue(t.Value))
+	}
+
+	return UploadOptions{
+		Metadata: head.Metadata,
+		Tagging:  tags.Encode(),
+	}, nil
+}
+
 func (storage *S3) DownloadXMLObject(ctx context.Context, data interface{}, bucket, key string) (bool, error) {
 	found, r, err := storage.DownloadObject(ctx, bucket, key)
 	if err != nil {
@@ -73,26 +243,92 @@ func (storage *S3) uploader() *s3manager.Uploader {
 }
 
 func (storage *S3) UploadObject(ctx context.Context, r io.Reader, bucket, key, content string) error {
-	_, err := storage.uploader().UploadWithContext(ctx, &s3manager.UploadInput{
-		ACL:         aws.String(s3.ObjectCannedACLPublicRead),
-		Bucket:      aws.String(bucket),
-		ContentType: aws.String(content),
-		Key:         aws.String(key),
-		Body:        r,
-	})
+	return storage.UploadObjectWithOptions(ctx, r, bucket, key, content, UploadOptions{})
+}
+
+// UploadObjectWithOptions uploads an object with the storage options overridden by opts.
+// The Content-Type and Cache-Control of repository objects are set by the cache policy of the storage.
+func (storage *S3) UploadObjectWithOptions(ctx context.Context, r io.Reader, bucket, key, content string, opts UploadOptions) error {
+	_, err := storage.upload(ctx, r, bucket, key, content, opts)
 	return err
 }
 
-func (storage *S3) UploadXMLObject(ctx context.Context, data interface{}, bucket, key string) error {
-	var (
-		pr, pw = io.Pipe()
-		e      = xml.NewEncoder(pw)
-	)
+func (storage *S3) upload(ctx context.Context, r io.Reader, bucket, key, content string, opts UploadOptions) (*s3manager.UploadOutput, error) {
+	options := storage.Options
+	if policy, ok := storage.Cache.For(key); ok {
+		content = policy.ContentType
+		options.CacheControl = policy.CacheControl
+	}
 
-	e.Indent("", "  ")
+	input := options.With(opts).uploadInput(bucket, key, content)
+	input.Body = r
+
+	return storage.uploader().UploadWithContext(ctx, input)
+}
+
+// UploadObjectVersion uploads an object like UploadObjectWithOptions and returns the version that was uploaded.
+// Uploads do not return the ETag of multipart objects, so it is read back after the upload.
+// In a bucket without versioning the object may have been replaced again before it is read back.
+func (storage *S3) UploadObjectVersion(ctx context.Context, r io.Reader, bucket, key, content string, opts UploadOptions) (*ObjectVersion, error) {
+	out, err := storage.upload(ctx, r, bucket, key, content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := s3.New(storage).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: out.VersionID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "stat uploaded object")
+	}
+	return &ObjectVersion{
+		Bucket:    bucket,
+		Key:       key,
+		ETag:      aws.StringValue(head.ETag),
+		VersionID: aws.StringValue(head.VersionId),
+	}, nil
+}
+
+// writeXML writes data to w as a metadata document laid out by yum.MetadataWriter.
+func writeXML(w io.Writer, data interface{}) error {
+	return writeXMLStream(w, func(e *xml.Encoder) error {
+		return e.Encode(data)
+	})
+}
+
+// writeXMLStream writes the metadata document written to the encoder by f to w, laid out by yum.MetadataWriter.
+func writeXMLStream(w io.Writer, f func(e *xml.Encoder) error) error {
+	mw := yum.NewMetadataWriter(w)
+
+	_, err := io.WriteString(mw, xml.Header)
+	if err == nil {
+		err = f(xml.NewEncoder(mw))
+	}
+
+	cerr := mw.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// MarshalXMLObject encodes data the same way as UploadXMLObject.
+func MarshalXMLObject(data interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := writeXML(&b, data)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (storage *S3) UploadXMLObject(ctx context.Context, data interface{}, bucket, key string) error {
+	pr, pw := io.Pipe()
 
 	errs := simpleConcurrentError(func() error {
-		err := e.Encode(data)
+		err := writeXML(pw, data)
 		_ = pw.CloseWithError(err)
 		return err
 	})
@@ -111,8 +347,34 @@ func (storage *S3) UploadXMLObject(ctx context.Context, data interface{}, bucket
 	return nil
 }
 
-func (storage *S3) DownloadCompressedXMLObject(ctx context.Context, data interface{}, bucket, key string) (bool, error) {
+type compressedReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (r *compressedReadCloser) Close() error {
+	_ = r.Reader.Close()
+	return r.body.Close()
+}
+
+// DownloadCompressedObject downloads a gzip compressed object, returning a reader of its decompressed contents.
+func (storage *S3) DownloadCompressedObject(ctx context.Context, bucket, key string) (bool, io.ReadCloser, error) {
 	found, r, err := storage.DownloadObject(ctx, bucket, key)
+	if err != nil || !found {
+		return found, nil, err
+	}
+
+	g, err := gzip.NewReader(r)
+	if err != nil {
+		_ = r.Close()
+		return false, nil, err
+	}
+
+	return true, &compressedReadCloser{Reader: g, body: r}, nil
+}
+
+func (storage *S3) DownloadCompressedXMLObject(ctx context.Context, data interface{}, bucket, key string) (bool, error) {
+	found, r, err := storage.DownloadCompressedObject(ctx, bucket, key)
 	if err != nil {
 		return false, errors.Wrap(err, "download compressed XML object")
 	}
@@ -121,31 +383,34 @@ func (storage *S3) DownloadCompressedXMLObject(ctx context.Context, data interfa
 	}
 
 	defer r.Close()
-	g, err := gzip.NewReader(r)
-	if err != nil {
-		return false, err
-	}
-
-	_ = g.Close()
-	return true, xml.NewDecoder(g).Decode(data)
+	return true, xml.NewDecoder(r).Decode(data)
 }
 
 func (storage *S3) UploadCompressedXMLObject(ctx context.Context, data interface{}, bucket, key string) (*XMLObject, error) {
+	return storage.UploadCompressedXMLStream(ctx, bucket, key, func(e *xml.Encoder) error {
+		return e.Encode(data)
+	})
+}
+
+// UploadCompressedXMLStream uploads a gzip compressed XML document written to the encoder by f.
+// f must flush the encoder before returning.
+func (storage *S3) UploadCompressedXMLStream(ctx context.Context, bucket, key string, f func(e *xml.Encoder) error) (*XMLObject, error) {
 	var (
-		pr, pw        = io.Pipe()
-		shaContent    = yum.SHA256() // SHA256 of raw content
-		shaCompressed = yum.SHA256() // SHA256 of compressed data
-		g             = gzip.NewWriter(io.MultiWriter(pw, shaCompressed))
-		w             = io.MultiWriter(g, shaContent)
-		e             = xml.NewEncoder(w)
+		pr, pw         = io.Pipe()
+		shaContent     = yum.SHA256() // SHA256 of raw content
+		shaCompressed  = yum.SHA256() // SHA256 of compressed data
+		sizeContent    = new(byteCounter)
+		sizeCompressed = new(byteCounter)
+		g              = gzip.NewWriter(io.MultiWriter(pw, shaCompressed, sizeCompressed))
+		w              = io.MultiWriter(g, shaContent, sizeContent)
 	)
 
-	e.Indent("", "  ")
-
 	errs := simpleConcurrentError(func() (err error) {
-		defer pw.CloseWithError(err)
+		defer func() {
+			_ = pw.CloseWithError(err)
+		}()
 
-		err = e.Encode(data)
+		err = writeXMLStream(w, f)
 		if err != nil {
 			return
 		}
@@ -173,5 +438,7 @@ func (storage *S3) UploadCompressedXMLObject(ctx context.Context, data interface
 		Key:             key,
 		ContentChecksum: shaContent.Sum(),
 		ObjectChecksum:  shaCompressed.Sum(),
+		ContentSize:     int64(*sizeContent),
+		ObjectSize:      int64(*sizeCompressed),
 	}, nil
 }
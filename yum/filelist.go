@@ -4,29 +4,97 @@ import (
 	"encoding/xml"
 )
 
+const (
+	FileTypeDir   = "dir"
+	FileTypeGhost = "ghost"
+)
+
+// File is a file contained in a package.
+// Type is empty for regular files, or one of FileTypeDir or FileTypeGhost.
+type File struct {
+	Type string `xml:"type,attr,omitempty"`
+	Name string `xml:",chardata"`
+}
+
 type Filelist struct {
-	PkgID   string   `xml:"pkgid,attr"`
-	Name    string   `xml:"name,attr"`
-	Arch    string   `xml:"arch,attr"`
-	Version Version  `xml:"version"`
-	Files   []string `xml:"file"`
+	PkgID   string    `xml:"pkgid,attr"`
+	Name    string    `xml:"name,attr"`
+	Arch    string    `xml:"arch,attr"`
+	Version Version   `xml:"version"`
+	Files   []File    `xml:"file"`
+	Unknown []Element `xml:",any"`
 }
 
 type FilelistData struct {
 	XMLName      xml.Name
 	PackageCount int        `xml:"packages,attr"`
 	Packages     []Filelist `xml:"package"`
+	Unknown      []Element  `xml:",any"`
+
+	// index of Packages by pkgid
+	index map[string]int
+	// sorted is the number of leading Packages known to be in canonical order, packages added since are sorted when encoded
+	sorted int
+}
+
+// MarshalXML encodes the filelist as a filelists.xml document with the filelists namespace,
+// regardless of the namespace the filelist was decoded from. Packages are written in canonical order.
+func (fl FilelistData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type filelistData FilelistData
+	fl.Sort()
+	fl.XMLName = xml.Name{}
+	return e.EncodeElement(filelistData(fl), filelistsRoot())
+}
+
+// reindex indexes the filelist, sorting it first unless it is already in canonical order.
+func (fl *FilelistData) reindex() {
+	fl.Sort()
+
+	fl.index = make(map[string]int, len(fl.Packages))
+	for i := len(fl.Packages) - 1; i >= 0; i-- {
+		fl.index[fl.Packages[i].PkgID] = i
+	}
 }
 
+// Add a Filelist to this filelist or replaces an existing one with the same pkgid.
 func (fl *FilelistData) Add(f Filelist) bool {
-	for i, p := range fl.Packages {
-		if p.PkgID == f.PkgID {
-			fl.Packages[i] = f
-			return true
-		}
+	return fl.Replace(f.PkgID, f)
+}
+
+// Replace replaces the Filelist identified by pkgid in place with f, keeping its position in the filelist.
+// If there is no such Filelist then f is added to the end of the filelist.
+// A Filelist already in the filelist with the pkgid of f is replaced by f rather than duplicated.
+func (fl *FilelistData) Replace(pkgid string, f Filelist) bool {
+	if fl.index == nil {
+		fl.reindex()
 	}
 
-	fl.Packages = append(fl.Packages, f)
-	fl.PackageCount = len(fl.Packages)
+	i, ok := fl.index[pkgid]
+	j, exists := fl.index[f.PkgID]
+	switch {
+	case ok && exists && i != j:
+		fl.Packages[j] = f
+		fl.remove(i)
+	case ok:
+		delete(fl.index, pkgid)
+		fl.index[f.PkgID] = i
+		fl.Packages[i] = f
+	case exists:
+		fl.Packages[j] = f
+	default:
+		fl.index[f.PkgID] = len(fl.Packages)
+		fl.Packages = append(fl.Packages, f)
+		fl.PackageCount = len(fl.Packages)
+	}
 	return true
-}
\ No newline at end of file
+}
+
+// remove removes the Filelist at i from the filelist and reindexes it.
+func (fl *FilelistData) remove(i int) {
+	fl.Packages = append(fl.Packages[:i], fl.Packages[i+1:]...)
+	fl.PackageCount = len(fl.Packages)
+	if i < fl.sorted {
+		fl.sorted--
+	}
+	fl.reindex()
+}
@@ -0,0 +1,61 @@
+package yum
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testFilelist(names ...string) *FilelistData {
+	fl := &FilelistData{}
+	for i, name := range names {
+		fl.Add(Filelist{PkgID: fmt.Sprintf("c%d", i+1), Name: name, Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	}
+	return fl
+}
+
+func pkgids(fl *FilelistData) string {
+	var ids []string
+	for _, f := range fl.Packages {
+		ids = append(ids, f.PkgID)
+	}
+	return fmt.Sprint(ids, fl.PackageCount)
+}
+
+func TestFilelistReplace(t *testing.T) {
+	fl := testFilelist("bash", "curl")
+
+	fl.Replace("c1", Filelist{PkgID: "c3", Name: "bash", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	if got := pkgids(fl); got != "[c3 c2] 2" {
+		t.Errorf("replaced in place: %s", got)
+	}
+
+	// replacing with a pkgid already in the filelist does not duplicate it
+	fl.Replace("c3", Filelist{PkgID: "c2", Name: "curl", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	if got := pkgids(fl); got != "[c2] 1" {
+		t.Errorf("replaced with an existing pkgid: %s", got)
+	}
+	fl.Replace("c9", Filelist{PkgID: "c2", Name: "curl", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	if got := pkgids(fl); got != "[c2] 1" {
+		t.Errorf("replaced a missing pkgid with an existing one: %s", got)
+	}
+
+	// the index is kept up to date
+	fl.Add(Filelist{PkgID: "c4", Name: "zlib", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	fl.Replace("c2", Filelist{PkgID: "c5", Name: "curl", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	if got := pkgids(fl); got != "[c5 c4] 2" {
+		t.Errorf("replaced after removal: %s", got)
+	}
+}
+
+func TestFilelistRemoveKeepsOrder(t *testing.T) {
+	fl := testFilelist("bash", "curl", "zlib")
+	fl.Sort()
+	fl.Add(Filelist{PkgID: "c4", Name: "acl", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+
+	// removing a sorted package while an unsorted one is pending still sorts the filelist when encoded
+	fl.Replace("c1", Filelist{PkgID: "c3", Name: "zlib", Arch: "x86_64", Version: Version{Epoch: "0", Ver: "1.0", Rel: "1"}})
+	fl.Sort()
+	if got := pkgids(fl); got != "[c4 c2 c3] 3" {
+		t.Errorf("filelist order: %s", got)
+	}
+}
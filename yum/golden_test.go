@@ -0,0 +1,164 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/rustylynch/go-rpmutils"
+	"testing"
+)
+
+// The golden files in testdata/golden are the primary.xml, filelists.xml and repomd.xml written for the packages
+// returned by goldenRPMs, in the layout of MetadataWriter. They are written by hand, not generated by createrepo_c,
+// and their checksums are placeholders, so they pin down the output of this package rather than prove parity with createrepo_c.
+
+// goldenRPMs returns the scanned packages of the golden files, in the reverse of their canonical order.
+func goldenRPMs() []*RPMObject {
+	return []*RPMObject{
+		{
+			Key: "Packages/curl-7.29.0-59.el7.x86_64.rpm",
+			RPM: RPM{
+				Release:     &rpmutils.NEVRA{Name: "curl", Epoch: "1", Version: "7.29.0", Release: "59.el7", Arch: "x86_64"},
+				Checksum:    Checksum{Type: "sha256", Checksum: "0c5b6f3a9e2b8e3d6c1a6b1c48f3b2d1e7a0f8c9d2b4e6a8c0e2f4a6b8d0e2f4"},
+				Size:        Size{Package: 277012, Installed: 540896, Archive: 543400},
+				Summary:     "A utility for getting files from remote servers (FTP, HTTP, and others)",
+				Description: "curl is a command line tool for transferring data with URL syntax.",
+				BuildTime:   1585757401,
+				FileTime:    1588621190,
+				Files: []File{
+					{Name: "/usr/bin/curl"},
+					{Name: "/usr/share/man/man1/curl.1.gz"},
+				},
+				Format: Format{
+					License:     "MIT",
+					Group:       "Applications/Internet",
+					BuildHost:   "x86-02.bsys.centos.org",
+					SourceRPM:   "curl-7.29.0-59.el7.src.rpm",
+					HeaderRange: HeaderRange{Start: 4504, End: 12188},
+					Provides:    Entries{{Name: "curl", Flags: "EQ", Epoch: "1", Ver: "7.29.0", Rel: "59.el7"}},
+					Conflicts:   Entries{{Name: "curl-minimal"}},
+				},
+			},
+		},
+		{
+			Key: "Packages/bash-4.2.46-34.el7.x86_64.rpm",
+			RPM: RPM{
+				Release:  &rpmutils.NEVRA{Name: "bash", Epoch: "0", Version: "4.2.46", Release: "34.el7", Arch: "x86_64"},
+				Checksum: Checksum{Type: "sha256", Checksum: "a2b46e0a9b8a2fd41bb9e3fe15de6d45c9de9a2a0edaa8ea7d3c0a0b3b6cbf1a"},
+				Size:     Size{Package: 1037976, Installed: 3667773, Archive: 3688088},
+				Summary:  "The GNU Bourne Again shell",
+				Description: "The GNU Bourne Again shell (Bash) is a shell or command language\n" +
+					`interpreter that is compatible with the Bourne shell (sh) & "sh -c".`,
+				Packager:  "CentOS BuildSystem <http://bugs.centos.org>",
+				URL:       "http://www.gnu.org/software/bash",
+				BuildTime: 1585757329,
+				FileTime:  1588621186,
+				Files: []File{
+					{Name: "/etc/bashrc.rpmsave", Type: FileTypeGhost},
+					{Name: "/usr/bin/bash"},
+					{Name: "/usr/bin/bashbug-64"},
+					{Name: "/usr/share/doc/bash-4.2.46", Type: FileTypeDir},
+					{Name: "/usr/share/doc/bash-4.2.46/COPYING"},
+				},
+				Format: Format{
+					License:     "GPLv3+",
+					Vendor:      "CentOS",
+					Group:       "System Environment/Shells",
+					BuildHost:   "x86-01.bsys.centos.org",
+					SourceRPM:   "bash-4.2.46-34.el7.src.rpm",
+					HeaderRange: HeaderRange{Start: 4504, End: 69284},
+					Provides: Entries{
+						{Name: "/bin/bash"},
+						{Name: "bash", Flags: "EQ", Epoch: "0", Ver: "4.2.46", Rel: "34.el7"},
+						{Name: "bash(x86-64)", Flags: "EQ", Epoch: "0", Ver: "4.2.46", Rel: "34.el7"},
+					},
+					Requires: Entries{
+						{Name: "/bin/sh", Pre: "1"},
+						{Name: "libc.so.6(GLIBC_2.15)(64bit)"},
+						{Name: "libtinfo.so.5()(64bit)"},
+					},
+					Obsoletes: Entries{{Name: "bash-doc", Flags: "LT", Epoch: "0", Ver: "4.2"}},
+				},
+			},
+		},
+	}
+}
+
+func goldenRepository() *Repository {
+	repo := newTestRepository()
+	repo.Update(goldenRPMs()...)
+	return repo
+}
+
+func compareGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	want := readFixture(t, "testdata/golden/"+name)
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestGoldenPrimary(t *testing.T) {
+	compareGolden(t, "primary.xml", encodeDocument(t, goldenRepository().Packages))
+}
+
+func TestGoldenFilelists(t *testing.T) {
+	compareGolden(t, "filelists.xml", encodeDocument(t, goldenRepository().Filelist))
+}
+
+func TestGoldenRepomd(t *testing.T) {
+	md := &MetadataData{Revision: "1588621200"}
+	md.Update(Metadata{
+		Type:            "primary",
+		Checksum:        Checksum{Type: "sha256", Checksum: "6b2cd0fa3d0e5e9a7e4c8bbcd7a9e9b4d6f0a1c3e5b7d9f1a3c5e7b9d1f3a5c7"},
+		ContentChecksum: &Checksum{Type: "sha256", Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		Location:        Location{Href: "repodata/6b2cd0fa3d0e5e9a7e4c8bbcd7a9e9b4d6f0a1c3e5b7d9f1a3c5e7b9d1f3a5c7-primary.xml.gz"},
+		Timestamp:       1588621200,
+		Size:            1342,
+		ContentSize:     4611,
+	})
+	md.Update(Metadata{
+		Type:            "filelists",
+		Checksum:        Checksum{Type: "sha256", Checksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		ContentChecksum: &Checksum{Type: "sha256", Checksum: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"},
+		Location:        Location{Href: "repodata/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08-filelists.xml.gz"},
+		Timestamp:       1588621200,
+		Size:            512,
+		ContentSize:     1024,
+	})
+	compareGolden(t, "repomd.xml", encodeDocument(t, md))
+}
+
+func TestGoldenMerge(t *testing.T) {
+	// merging the packages into an empty repository writes the same documents
+	m := NewMerge(goldenRPMs()...)
+	err := m.ScanPrimary(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergePrimary(nil, e)
+	})
+	filelists := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergeFilelists(nil, e)
+	})
+	compareGolden(t, "primary.xml", primary)
+	compareGolden(t, "filelists.xml", filelists)
+}
+
+func TestGoldenDecode(t *testing.T) {
+	// the golden files are written back unchanged once decoded
+	for _, c := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"primary.xml", &PackageData{}},
+		{"filelists.xml", &FilelistData{}},
+		{"repomd.xml", &MetadataData{}},
+	} {
+		err := xml.Unmarshal(readFixture(t, "testdata/golden/"+c.name), c.v)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		compareGolden(t, c.name, encodeDocument(t, c.v))
+	}
+}
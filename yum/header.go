@@ -0,0 +1,140 @@
+package yum
+
+import (
+	"github.com/rustylynch/go-rpmutils"
+	"strings"
+)
+
+// Dependency tags and flags not defined by rpmutils
+const (
+	tagConflictFlags   = 1053
+	tagConflictName    = 1054
+	tagConflictVersion = 1055
+
+	sensePreReq     = 1 << 6
+	senseScriptPre  = 1 << 9
+	senseScriptPost = 1 << 10
+)
+
+func headerString(hdr *rpmutils.RpmHeader, tag int) (string, error) {
+	v, err := hdr.GetStrings(tag)
+	if _, ok := err.(rpmutils.NoSuchTagError); ok {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(v) == 0 {
+		return "", nil
+	}
+	// only the first value of an i18n string is used
+	return v[0], nil
+}
+
+func headerInt(hdr *rpmutils.RpmHeader, tag int) (int64, error) {
+	v, err := hdr.GetInts(tag)
+	if _, ok := err.(rpmutils.NoSuchTagError); ok {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return int64(v[0]), nil
+}
+
+// parseEVR splits an [epoch:]version[-release] string as found in dependency versions.
+func parseEVR(evr string) (epoch, ver, rel string) {
+	epoch = "0"
+	if i := strings.Index(evr, ":"); i != -1 {
+		epoch, evr = evr[:i], evr[i+1:]
+	}
+	ver = evr
+	if i := strings.LastIndex(evr, "-"); i != -1 {
+		ver, rel = evr[:i], evr[i+1:]
+	}
+	return
+}
+
+func senseFlags(flags int) string {
+	switch flags & (rpmutils.RPMSENSE_LESS | rpmutils.RPMSENSE_GREATER | rpmutils.RPMSENSE_EQUAL) {
+	case rpmutils.RPMSENSE_LESS:
+		return "LT"
+	case rpmutils.RPMSENSE_GREATER:
+		return "GT"
+	case rpmutils.RPMSENSE_EQUAL:
+		return "EQ"
+	case rpmutils.RPMSENSE_LESS | rpmutils.RPMSENSE_EQUAL:
+		return "LE"
+	case rpmutils.RPMSENSE_GREATER | rpmutils.RPMSENSE_EQUAL:
+		return "GE"
+	}
+	return ""
+}
+
+// headerEntries reads the dependencies stored in a set of name, flags and version tags.
+// rpmlib() dependencies and duplicates are skipped as they are by createrepo.
+func headerEntries(hdr *rpmutils.RpmHeader, nameTag, flagsTag, versionTag int) (Entries, error) {
+	names, err := hdr.GetStrings(nameTag)
+	if _, ok := err.(rpmutils.NoSuchTagError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := hdr.GetInts(flagsTag)
+	if _, ok := err.(rpmutils.NoSuchTagError); !ok && err != nil {
+		return nil, err
+	}
+
+	versions, err := hdr.GetStrings(versionTag)
+	if _, ok := err.(rpmutils.NoSuchTagError); !ok && err != nil {
+		return nil, err
+	}
+
+	var (
+		entries Entries
+		seen    = make(map[Entry]bool, len(names))
+	)
+	for i, name := range names {
+		if strings.HasPrefix(name, "rpmlib(") {
+			continue
+		}
+
+		e := Entry{Name: name}
+		if i < len(flags) {
+			e.Flags = senseFlags(flags[i])
+			if flags[i]&(sensePreReq|senseScriptPre|senseScriptPost) != 0 {
+				e.Pre = "1"
+			}
+		}
+		if i < len(versions) && versions[i] != "" {
+			e.Epoch, e.Ver, e.Rel = parseEVR(versions[i])
+		}
+
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func fileType(fi rpmutils.FileInfo) string {
+	switch {
+	case fi.Flags()&rpmutils.RPMFILE_GHOST != 0:
+		return FileTypeGhost
+	case fi.Mode()&0170000 == 0040000:
+		return FileTypeDir
+	}
+	return ""
+}
+
+// isPrimaryFile returns true if a file is listed in primary.xml as well as filelists.xml.
+func isPrimaryFile(name string) bool {
+	return strings.HasPrefix(name, "/etc/") || strings.Contains(name, "bin/") || name == "/usr/lib/sendmail"
+}
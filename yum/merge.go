@@ -0,0 +1,369 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Merge applies a batch of RPMObjects to the packages of an existing repository while streaming its metadata
+// from an xml.Decoder to an xml.Encoder, so that memory use does not grow with the size of the repository.
+//
+// Unchanged <package> elements are passed straight through to the output
+// and packages replaced by the batch are re-encoded in place.
+// New packages are inserted before the first existing package that sorts after them,
+// so merging into canonically sorted metadata keeps it sorted.
+//
+// ScanPrimary must be called with the existing primary metadata before merging either document.
+type Merge struct {
+	order    []string
+	packages map[string]*RPMObject
+
+	// populated by ScanPrimary
+	count     int
+	buildTime int64
+	existing  map[string]bool       // NEVRA of packages in the batch that are already in the repository
+	unchanged map[string]bool       // NEVRA of existing packages with an equal checksum
+	replaced  map[string]*RPMObject // by pkgid of the replaced package
+}
+
+// NewMerge creates a new Merge updating a repository with the given RPMObjects.
+func NewMerge(objects ...*RPMObject) *Merge {
+	m := &Merge{
+		packages: make(map[string]*RPMObject, len(objects)),
+	}
+	for _, f := range objects {
+		nevra := f.Package().NEVRA()
+		if _, ok := m.packages[nevra]; !ok {
+			m.order = append(m.order, nevra)
+		}
+		m.packages[nevra] = f
+	}
+	return m
+}
+
+// Count returns the number of packages in the repository after merging.
+func (m *Merge) Count() int {
+	return m.count + len(m.order) - len(m.existing)
+}
+
+// Updated returns true if merging changes the repository.
+func (m *Merge) Updated() bool {
+	return len(m.unchanged) < len(m.order)
+}
+
+// BuildTime returns the newest build time of all packages in the repository after merging.
+func (m *Merge) BuildTime() int64 {
+	t := m.buildTime
+	for _, f := range m.packages {
+		if f.BuildTime > t {
+			t = f.BuildTime
+		}
+	}
+	return t
+}
+
+// added returns the packages of the batch that are new to the repository in canonical order.
+func (m *Merge) added() []*RPMObject {
+	var added []*RPMObject
+	for _, nevra := range m.order {
+		if !m.existing[nevra] {
+			added = append(added, m.packages[nevra])
+		}
+	}
+	sort.SliceStable(added, func(i, j int) bool {
+		return added[i].sortKey().Less(added[j].sortKey())
+	})
+	return added
+}
+
+// ScanPrimary scans existing primary metadata, finding which packages of the batch already exist in the repository.
+// A nil reader is treated as an empty repository.
+func (m *Merge) ScanPrimary(r io.Reader) error {
+	m.count = 0
+	m.buildTime = 0
+	m.existing = make(map[string]bool)
+	m.unchanged = make(map[string]bool)
+	m.replaced = make(map[string]*RPMObject)
+	if r == nil {
+		return nil
+	}
+
+	return streamElements(xml.NewDecoder(r), func(start xml.StartElement) error { return nil }, func(tokens []xml.Token) error {
+		if !isPackage(tokens) {
+			return nil
+		}
+
+		var pkg Package
+		err := decodeTokens(tokens, &pkg)
+		if err != nil {
+			return err
+		}
+
+		m.count++
+		f, ok := m.packages[pkg.NEVRA()]
+		if !ok {
+			if pkg.Time.Build > m.buildTime {
+				m.buildTime = pkg.Time.Build
+			}
+			return nil
+		}
+
+		m.existing[pkg.NEVRA()] = true
+		if f.Checksum.Equals(pkg.Checksum.Checksum) {
+			m.unchanged[pkg.NEVRA()] = true
+		} else {
+			m.replaced[pkg.Checksum.Checksum.Checksum] = f
+		}
+		return nil
+	}, nil)
+}
+
+// MergePrimary merges the batch into existing primary metadata read from r, writing the result to e.
+// A nil reader is treated as an empty repository.
+func (m *Merge) MergePrimary(r io.Reader, e *xml.Encoder) error {
+	return m.merge(r, e, primaryRoot(), func(tokens []xml.Token) (sortKey, interface{}, error) {
+		var pkg Package
+		err := decodeTokens(tokens, &pkg)
+		if err != nil {
+			return sortKey{}, nil, err
+		}
+		f, ok := m.replaced[pkg.Checksum.Checksum.Checksum]
+		if ok && f.Package().NEVRA() == pkg.NEVRA() {
+			return pkg.sortKey(), f.Package(), nil
+		}
+		return pkg.sortKey(), nil, nil
+	}, func(f *RPMObject) interface{} {
+		return f.Package()
+	})
+}
+
+// MergeFilelists merges the batch into existing filelists metadata read from r, writing the result to e.
+// A nil reader is treated as an empty repository.
+func (m *Merge) MergeFilelists(r io.Reader, e *xml.Encoder) error {
+	return m.merge(r, e, filelistsRoot(), func(tokens []xml.Token) (sortKey, interface{}, error) {
+		var fl Filelist
+		err := decodeTokens(tokens, &fl)
+		if err != nil {
+			return sortKey{}, nil, err
+		}
+		f, ok := m.replaced[fl.PkgID]
+		if ok {
+			return fl.sortKey(), f.Filelist(), nil
+		}
+		return fl.sortKey(), nil, nil
+	}, func(f *RPMObject) interface{} {
+		return f.Filelist()
+	})
+}
+
+// merge streams a metadata document from r to e.
+// inspect is called with the tokens of each existing package and returns its sort key
+// and the value to encode in its place, or nil to keep it.
+// add returns the value to encode for each package in the batch that is new to the repository.
+func (m *Merge) merge(r io.Reader, e *xml.Encoder, root xml.StartElement, inspect func([]xml.Token) (sortKey, interface{}, error), add func(*RPMObject) interface{}) error {
+	pending := m.added()
+
+	// insert writes pending packages that sort before the given key, or all of them if key is nil
+	insert := func(key *sortKey) error {
+		for len(pending) > 0 && (key == nil || pending[0].sortKey().Less(*key)) {
+			err := e.EncodeElement(add(pending[0]), xml.StartElement{Name: xml.Name{Local: "package"}})
+			if err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+		return nil
+	}
+
+	start := func(start xml.StartElement) error {
+		return e.EncodeToken(rootElement(start, root, m.Count()))
+	}
+
+	element := func(tokens []xml.Token) error {
+		var v interface{}
+		if isPackage(tokens) {
+			key, replacement, err := inspect(tokens)
+			if err != nil {
+				return err
+			}
+			err = insert(&key)
+			if err != nil {
+				return err
+			}
+			v = replacement
+		}
+		if v != nil {
+			return e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: "package"}})
+		}
+		for _, t := range tokens {
+			err := e.EncodeToken(flatten(t))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	end := func(end xml.EndElement) error {
+		err := insert(nil)
+		if err != nil {
+			return err
+		}
+		err = e.EncodeToken(flatten(end))
+		if err != nil {
+			return err
+		}
+		return e.Flush()
+	}
+
+	if r == nil {
+		err := start(root)
+		if err != nil {
+			return err
+		}
+		return end(root.End())
+	}
+
+	return streamElements(xml.NewDecoder(r), start, element, end)
+}
+
+// streamElements reads a metadata document from d calling start with the root element,
+// element with the tokens of each child element of the root and end with the closing root element.
+// Tokens are read raw so that namespace prefixes are kept as written in the document.
+func streamElements(d *xml.Decoder, start func(xml.StartElement) error, element func([]xml.Token) error, end func(xml.EndElement) error) error {
+	var (
+		depth  int
+		tokens []xml.Token
+	)
+
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tt := t.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				err = start(tt.Copy())
+				break
+			}
+			tokens = append(tokens, tt.Copy())
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				if end != nil {
+					err = end(tt)
+				}
+				break
+			}
+			tokens = append(tokens, tt)
+			if depth == 1 {
+				err = element(trimSpace(tokens))
+				tokens = tokens[:0]
+			}
+		default:
+			// whitespace between packages is replaced by the encoder's indentation
+			if depth > 1 {
+				tokens = append(tokens, xml.CopyToken(t))
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isPackage(tokens []xml.Token) bool {
+	return tokens[0].(xml.StartElement).Name.Local == "package"
+}
+
+// trimSpace removes whitespace-only character data between elements,
+// keeping it where it is the only content of an element.
+func trimSpace(tokens []xml.Token) []xml.Token {
+	trimmed := make([]xml.Token, 0, len(tokens))
+	for i, t := range tokens {
+		if cd, ok := t.(xml.CharData); ok && len(bytes.TrimSpace(cd)) == 0 {
+			_, afterStart := tokens[i-1].(xml.StartElement)
+			_, beforeEnd := tokens[i+1].(xml.EndElement)
+			if !afterStart || !beforeEnd {
+				continue
+			}
+		}
+		trimmed = append(trimmed, t)
+	}
+	return trimmed
+}
+
+// tokenSlice implements xml.TokenReader over a slice of tokens.
+type tokenSlice []xml.Token
+
+func (ts *tokenSlice) Token() (xml.Token, error) {
+	if len(*ts) == 0 {
+		return nil, io.EOF
+	}
+	t := (*ts)[0]
+	*ts = (*ts)[1:]
+	return t, nil
+}
+
+func decodeTokens(tokens []xml.Token, v interface{}) error {
+	ts := tokenSlice(tokens)
+	return xml.NewTokenDecoder(&ts).Decode(v)
+}
+
+// flatten converts a raw token into one that the encoder writes back out with its original namespace prefixes.
+func flatten(t xml.Token) xml.Token {
+	switch tt := t.(type) {
+	case xml.StartElement:
+		start := xml.StartElement{
+			Name: flattenName(tt.Name),
+			Attr: make([]xml.Attr, len(tt.Attr)),
+		}
+		for i, a := range tt.Attr {
+			start.Attr[i] = xml.Attr{Name: flattenName(a.Name), Value: a.Value}
+		}
+		return start
+	case xml.EndElement:
+		return xml.EndElement{Name: flattenName(tt.Name)}
+	}
+	return t
+}
+
+func flattenName(n xml.Name) xml.Name {
+	if n.Space == "" {
+		return n
+	}
+	return xml.Name{Local: n.Space + ":" + n.Local}
+}
+
+func attr(start xml.StartElement, name string) (string, bool) {
+	for _, a := range start.Attr {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// rootElement returns the root element of a metadata document with an updated package count.
+// The namespaces of root are always declared, any other attributes of the existing root element are kept.
+func rootElement(existing, root xml.StartElement, count int) xml.StartElement {
+	start := root.Copy()
+	for _, a := range flatten(existing).(xml.StartElement).Attr {
+		if _, ok := attr(start, a.Name.Local); ok || a.Name.Local == "packages" {
+			continue
+		}
+		start.Attr = append(start.Attr, a)
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "packages"}, Value: strconv.Itoa(count)})
+	return start
+}
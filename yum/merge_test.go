@@ -0,0 +1,144 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// mergeBatch updates the packages of testdata/merge-primary.xml: bash 4.2 is unchanged, curl is replaced and bash 4.10 and zlib are new.
+func mergeBatch() []*RPMObject {
+	return []*RPMObject{
+		newTestRPM("zlib", "1.2.7", "1", "c3"),
+		newTestRPM("bash", "4.2", "1", "c1"),
+		newTestRPM("curl", "7.29", "1", "c4"),
+		newTestRPM("bash", "4.10", "1", "c5"),
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// updateFixtures updates the fixture metadata in memory with Repository.Update, returning the encoded primary and filelists.
+func updateFixtures(t *testing.T, primary, filelists []byte, batch []*RPMObject) ([]byte, []byte) {
+	t.Helper()
+	repo := newTestRepository()
+	err := xml.Unmarshal(primary, repo.Packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xml.Unmarshal(filelists, repo.Filelist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Update(batch...)
+	return encodeDocument(t, repo.Packages), encodeDocument(t, repo.Filelist)
+}
+
+// mergeFixtures merges the batch into the fixture metadata with Merge, returning the merged primary and filelists.
+func mergeFixtures(t *testing.T, primary, filelists []byte, batch []*RPMObject) (*Merge, []byte, []byte) {
+	t.Helper()
+	m := NewMerge(batch...)
+	err := m.ScanPrimary(bytes.NewReader(primary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedPrimary := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergePrimary(bytes.NewReader(primary), e)
+	})
+	mergedFilelists := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergeFilelists(bytes.NewReader(filelists), e)
+	})
+	return m, mergedPrimary, mergedFilelists
+}
+
+func TestMergeMatchesUpdate(t *testing.T) {
+	primary := readFixture(t, "testdata/merge-primary.xml")
+	filelists := readFixture(t, "testdata/merge-filelists.xml")
+	batch := mergeBatch()
+
+	m, mergedPrimary, mergedFilelists := mergeFixtures(t, primary, filelists, batch)
+	if !m.Updated() || m.Count() != 4 {
+		t.Errorf("merge updated %t with %d packages", m.Updated(), m.Count())
+	}
+
+	wantPrimary, wantFilelists := updateFixtures(t, primary, filelists, batch)
+	if !bytes.Equal(mergedPrimary, wantPrimary) {
+		t.Errorf("merged primary differs from update:\n%s\nwant:\n%s", mergedPrimary, wantPrimary)
+	}
+	if !bytes.Equal(mergedFilelists, wantFilelists) {
+		t.Errorf("merged filelists differs from update:\n%s\nwant:\n%s", mergedFilelists, wantFilelists)
+	}
+
+	// unchanged packages keep the elements that are not modelled
+	for _, s := range []string{"<rpm:suggests>", "<extension>kept as it is</extension>"} {
+		if !bytes.Contains(mergedPrimary, []byte(s)) {
+			t.Errorf("merged primary is missing %s", s)
+		}
+	}
+	// a replaced package is written from the batch
+	if bytes.Contains(mergedPrimary, []byte("<rpm:recommends>")) || bytes.Contains(mergedFilelists, []byte(`pkgid="c2"`)) {
+		t.Error("replaced package was kept")
+	}
+
+	var pd PackageData
+	err := xml.Unmarshal(mergedPrimary, &pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range pd.Packages {
+		names = append(names, p.NEVRA())
+	}
+	want := "[bash-0:4.2-1.x86_64 bash-0:4.10-1.x86_64 curl-0:7.29-1.x86_64 zlib-0:1.2.7-1.x86_64]"
+	if fmt.Sprint(names) != want || pd.PackageCount != 4 {
+		t.Errorf("merged %d packages %v, want %s", pd.PackageCount, names, want)
+	}
+}
+
+func TestMergeUnchanged(t *testing.T) {
+	primary := readFixture(t, "testdata/merge-primary.xml")
+
+	m := NewMerge(newTestRPM("bash", "4.2", "1", "c1"))
+	err := m.ScanPrimary(bytes.NewReader(primary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Updated() {
+		t.Error("merging an unchanged package updates the repository")
+	}
+}
+
+func TestMergeEmptyRepository(t *testing.T) {
+	batch := mergeBatch()
+	empty := encodeDocument(t, &PackageData{})
+	emptyFilelists := encodeDocument(t, &FilelistData{})
+
+	m := NewMerge(batch...)
+	err := m.ScanPrimary(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedPrimary := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergePrimary(nil, e)
+	})
+	mergedFilelists := encodeStream(t, func(e *xml.Encoder) error {
+		return m.MergeFilelists(nil, e)
+	})
+
+	wantPrimary, wantFilelists := updateFixtures(t, empty, emptyFilelists, batch)
+	if !bytes.Equal(mergedPrimary, wantPrimary) {
+		t.Errorf("merged primary differs from update:\n%s\nwant:\n%s", mergedPrimary, wantPrimary)
+	}
+	if !bytes.Equal(mergedFilelists, wantFilelists) {
+		t.Errorf("merged filelists differs from update:\n%s\nwant:\n%s", mergedFilelists, wantFilelists)
+	}
+}
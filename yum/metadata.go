@@ -1,21 +1,204 @@
 package yum
 
 import (
+	"crypto/sha256"
 	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
 )
 
+// Metadata is a data file listed by repomd.xml.
+// Data decoded from repomd.xml is written back with its elements in their original order,
+// so that elements of other tools stay where they were, such as the zchunk header-checksum and header-size.
 type Metadata struct {
-	Type      string   `xml:"type,attr"`
-	Location  Location `xml:"location"`
-	Timestamp int64    `xml:"timestamp"`
+	Type            string    `xml:"type,attr"`
+	Checksum        Checksum  `xml:"checksum"`
+	ContentChecksum *Checksum `xml:"open-checksum,omitempty"`
+	Location        Location  `xml:"location"`
+	Timestamp       int64     `xml:"timestamp,omitempty"`
+	Size            int64     `xml:"size,omitempty"`
+	ContentSize     int64     `xml:"open-size,omitempty"`
+	Unknown         []Element `xml:",any"`
 
-	Checksum        Checksum `xml:"checksum"`
-	ContentChecksum Checksum `xml:"open-checksum"`
+	// order is the names of the elements as they were decoded, with an empty name for each unknown element
+	order []string
+}
+
+// metadataElements are the names of the elements of Metadata in the order they are written by default.
+var metadataElements = []string{"checksum", "open-checksum", "location", "timestamp", "size", "open-size"}
+
+// element returns the value of the element of the metadata with name, or false if it is not written.
+func (m Metadata) element(name string) (interface{}, bool) {
+	switch name {
+	case "checksum":
+		return m.Checksum, true
+	case "open-checksum":
+		return m.ContentChecksum, m.ContentChecksum != nil
+	case "location":
+		return m.Location, true
+	case "timestamp":
+		return m.Timestamp, m.Timestamp != 0
+	case "size":
+		return m.Size, m.Size != 0
+	case "open-size":
+		return m.ContentSize, m.ContentSize != 0
+	}
+	return nil, false
+}
+
+func (m *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	tokens, err := readElement(d, start)
+	if err != nil {
+		return err
+	}
+
+	type metadata Metadata
+	err = decodeTokens(tokens, (*metadata)(m))
+	if err != nil {
+		return err
+	}
+
+	m.order = nil
+	depth := 0
+	for _, t := range tokens {
+		switch tt := t.(type) {
+		case xml.StartElement:
+			depth++
+			if depth != 2 {
+				continue
+			}
+			name := ""
+			for _, known := range metadataElements {
+				if tt.Name.Local == known {
+					name = known
+				}
+			}
+			m.order = append(m.order, name)
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// MarshalXML writes the elements of the metadata in the order they were decoded,
+// followed by any elements that were not decoded in the default order and then any remaining unknown elements.
+func (m Metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: m.Type})
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	writeElement := func(name string) error {
+		if written[name] {
+			return nil
+		}
+		written[name] = true
+		v, ok := m.element(name)
+		if !ok {
+			return nil
+		}
+		return e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+	}
+
+	unknown := m.Unknown
+	for _, name := range m.order {
+		if name != "" {
+			err = writeElement(name)
+		} else if len(unknown) > 0 {
+			err = unknown[0].MarshalXML(e, start)
+			unknown = unknown[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range metadataElements {
+		err = writeElement(name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, el := range unknown {
+		err = el.MarshalXML(e, start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+// Distro identifies a distribution a repository is built for by its CPE name.
+type Distro struct {
+	CPEID string `xml:"cpeid,attr,omitempty" json:"cpeid,omitempty"`
+	Name  string `xml:",chardata" json:"name,omitempty"`
+}
+
+// Tags describes the content of a repository.
+type Tags struct {
+	Distro  []Distro  `xml:"distro" json:"distro,omitempty"`
+	Content []string  `xml:"content" json:"content,omitempty"`
+	Repo    []string  `xml:"repo" json:"repo,omitempty"`
+	Unknown []Element `xml:",any" json:"-"`
 }
 
 type MetadataData struct {
-	XMLName xml.Name
-	Data    []Metadata `xml:"data"`
+	XMLName  xml.Name
+	Revision string     `xml:"revision"`
+	Tags     *Tags      `xml:"tags"`
+	Data     []Metadata `xml:"data"`
+	Unknown  []Element  `xml:",any"`
+}
+
+// MarshalXML encodes the metadata as a repomd.xml document with the repo and rpm namespaces,
+// regardless of the namespace the metadata was decoded from.
+func (md MetadataData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type metadataData MetadataData
+	md.XMLName = xml.Name{}
+	return e.EncodeElement(metadataData(md), repomdRoot())
+}
+
+// ContentRevision returns a revision derived from the checksums of all data in the metadata,
+// so that the same repository content always has the same revision.
+func (md MetadataData) ContentRevision() string {
+	data := make([]string, len(md.Data))
+	for i, d := range md.Data {
+		data[i] = fmt.Sprintf("%s %s %s\n", d.Type, d.Checksum.Type, d.Checksum.Checksum)
+	}
+	sort.Strings(data)
+
+	h := sha256.New()
+	for _, d := range data {
+		_, _ = h.Write([]byte(d))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// SetTags replaces the distro, content and repo tags of the metadata, keeping any other existing tags.
+func (md *MetadataData) SetTags(tags Tags) {
+	if md.Tags != nil {
+		tags.Unknown = md.Tags.Unknown
+	}
+	md.Tags = &tags
+}
+
+// BumpRevision sets the revision of the metadata to revision.
+// If both the existing and new revisions are numeric the new revision is always greater than the existing one.
+func (md *MetadataData) BumpRevision(revision string) {
+	next, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		md.Revision = revision
+		return
+	}
+	prev, err := strconv.ParseInt(md.Revision, 10, 64)
+	if err == nil && next <= prev {
+		next = prev + 1
+	}
+	md.Revision = strconv.FormatInt(next, 10)
 }
 
 func (md MetadataData) IndexOf(t string) int {
@@ -27,6 +210,14 @@ func (md MetadataData) IndexOf(t string) int {
 	return -1
 }
 
+// Remove removes the data of the given type from the metadata.
+func (md *MetadataData) Remove(t string) {
+	ix := md.IndexOf(t)
+	if ix != -1 {
+		md.Data = append(md.Data[:ix], md.Data[ix+1:]...)
+	}
+}
+
 func (md *MetadataData) Update(d Metadata) {
 	ix := md.IndexOf(d.Type)
 	if ix == -1 {
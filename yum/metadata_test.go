@@ -0,0 +1,42 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMetadataDataRoundTrip(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/roundtrip-repomd.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var md MetadataData
+	err = xml.Unmarshal(want, &md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data types written by other tools are kept as they are
+	got := encodeDocument(t, md)
+	if !bytes.Equal(got, want) {
+		t.Errorf("round trip differs:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetadataOmitsEmpty(t *testing.T) {
+	got, err := xml.Marshal(Metadata{
+		Type:     "group",
+		Checksum: Checksum{Type: "sha256", Checksum: "c1"},
+		Location: Location{Href: "repodata/c1-comps.xml"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<Metadata type="group"><checksum type="sha256">c1</checksum><location href="repodata/c1-comps.xml"></location></Metadata>`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
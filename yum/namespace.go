@@ -0,0 +1,161 @@
+package yum
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const (
+	NamespaceCommon    = "http://linux.duke.edu/metadata/common"
+	NamespaceFilelists = "http://linux.duke.edu/metadata/filelists"
+	NamespaceRepo      = "http://linux.duke.edu/metadata/repo"
+	NamespaceRPM       = "http://linux.duke.edu/metadata/rpm"
+)
+
+// Root elements are built with flat names so that the encoder writes namespace declarations
+// and the rpm: prefix exactly as createrepo does, rather than inventing its own prefixes.
+
+func xmlns(space string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: space}
+}
+
+func xmlnsRPM() xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: "xmlns:rpm"}, Value: NamespaceRPM}
+}
+
+// primaryRoot returns the root element of primary.xml
+func primaryRoot() xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Local: "metadata"},
+		Attr: []xml.Attr{xmlns(NamespaceCommon), xmlnsRPM()},
+	}
+}
+
+// filelistsRoot returns the root element of filelists.xml
+func filelistsRoot() xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Local: "filelists"},
+		Attr: []xml.Attr{xmlns(NamespaceFilelists)},
+	}
+}
+
+// repomdRoot returns the root element of repomd.xml
+func repomdRoot() xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Local: "repomd"},
+		Attr: []xml.Attr{xmlns(NamespaceRepo), xmlnsRPM()},
+	}
+}
+
+// isNamespaceURI returns true if space is a namespace URI translated by the decoder rather than a raw prefix.
+func isNamespaceURI(space string) bool {
+	return strings.ContainsAny(space, ":/")
+}
+
+// prefixName converts a namespaced element name as read by the decoder into the flat prefixed name used by the encoder.
+// Names in the rpm namespace become rpm:name, names in the common namespace lose their namespace
+// and raw prefixes are kept as they were written.
+func prefixName(n xml.Name) xml.Name {
+	switch {
+	case n.Space == "":
+		return n
+	case n.Space == NamespaceRPM:
+		return xml.Name{Local: "rpm:" + n.Local}
+	case isNamespaceURI(n.Space):
+		return xml.Name{Local: n.Local}
+	}
+	return xml.Name{Local: n.Space + ":" + n.Local}
+}
+
+// prefixStart converts a start element as read by the decoder into one written by the encoder with flat prefixed names.
+// An element in a namespace other than those of the metadata documents declares its namespace as the default
+// so that it is written back in the same namespace.
+func prefixStart(start xml.StartElement) xml.StartElement {
+	out := xml.StartElement{Name: prefixName(start.Name)}
+	switch start.Name.Space {
+	case "", NamespaceRPM, NamespaceCommon, NamespaceFilelists, NamespaceRepo:
+	default:
+		if isNamespaceURI(start.Name.Space) {
+			out.Attr = append(out.Attr, xmlns(start.Name.Space))
+		}
+	}
+
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			// default namespace declarations are replaced by the namespace of the element
+			continue
+		case a.Name.Space == "xmlns" || a.Name.Space == "xml":
+			a.Name = xml.Name{Local: a.Name.Space + ":" + a.Name.Local}
+		case a.Name.Space == "http://www.w3.org/XML/1998/namespace":
+			a.Name = xml.Name{Local: "xml:" + a.Name.Local}
+		default:
+			a.Name = prefixName(a.Name)
+		}
+		out.Attr = append(out.Attr, a)
+	}
+	return out
+}
+
+// readElement reads the tokens of the element started by start from the decoder,
+// converting element names to the flat prefixed names used by the encoder.
+func readElement(d *xml.Decoder, start xml.StartElement) ([]xml.Token, error) {
+	tokens := []xml.Token{prefixStart(start)}
+	for depth := 1; depth > 0; {
+		t, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch tt := t.(type) {
+		case xml.StartElement:
+			depth++
+			t = prefixStart(tt)
+		case xml.EndElement:
+			depth--
+			t = xml.EndElement{Name: prefixName(tt.Name)}
+		default:
+			t = xml.CopyToken(t)
+		}
+		tokens = append(tokens, t)
+	}
+	return trimSpace(tokens), nil
+}
+
+// decodePrefixed decodes the element started by start into v, matching element names by their prefixed name (e.g. rpm:license)
+// regardless of whether the decoder translated the prefix to its namespace.
+func decodePrefixed(d *xml.Decoder, start xml.StartElement, v interface{}) error {
+	tokens, err := readElement(d, start)
+	if err != nil {
+		return err
+	}
+	return decodeTokens(tokens, v)
+}
+
+// Element is an XML element that is not modelled by this package.
+// It is kept as the tokens it was read as so that metadata written by other tools is written back out unchanged.
+type Element struct {
+	Tokens []xml.Token
+}
+
+func (el *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	tokens, err := readElement(d, start)
+	if err != nil {
+		return err
+	}
+	el.Tokens = tokens
+	return nil
+}
+
+func (el Element) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	for _, t := range el.Tokens {
+		err := e.EncodeToken(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
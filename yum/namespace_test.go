@@ -0,0 +1,47 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestPrefixedNamespaces(t *testing.T) {
+	// the rpm namespace declared under another prefix, and the common namespace under a prefix of its own
+	doc := strings.Replace(string(readFixture(t, "testdata/golden/primary.xml")), "rpm:", "r:", -1)
+	doc = strings.Replace(doc, `xmlns:rpm="`, `xmlns:c="`+NamespaceCommon+`" xmlns:r="`, 1)
+	doc = strings.Replace(doc, "<package ", "<c:package ", -1)
+	doc = strings.Replace(doc, "</package>", "</c:package>", -1)
+
+	var pd PackageData
+	err := xml.Unmarshal([]byte(doc), &pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pd.Packages) != 2 || pd.Packages[0].Format.License != "GPLv3+" || len(pd.Packages[0].Format.Requires) != 3 {
+		t.Fatalf("decoded %+v", pd.Packages)
+	}
+
+	// packages are always written with the rpm: prefix of the root element
+	compareGolden(t, "primary.xml", encodeDocument(t, pd))
+}
+
+func TestUnknownNamespaces(t *testing.T) {
+	doc := `<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="0">` +
+		`<ext:checked xmlns:ext="https://example.com/ext" by="test">yes</ext:checked>` +
+		`</metadata>`
+
+	var pd PackageData
+	err := xml.Unmarshal([]byte(doc), &pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// elements in other namespaces declare their namespace as the default
+	got := encodeDocument(t, pd)
+	want := `<checked xmlns="https://example.com/ext" xmlns:ext="https://example.com/ext" by="test">yes</checked>`
+	if !bytes.Contains(got, []byte(want)) {
+		t.Errorf("unknown element not written in its namespace:\n%s", got)
+	}
+}
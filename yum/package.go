@@ -2,31 +2,115 @@ package yum
 
 import (
 	"encoding/xml"
+	"fmt"
 )
 
 type Version struct {
 	Epoch string `xml:"epoch,attr"`
-	Rel   string `xml:"rel,attr"`
 	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
 }
 
 func (v Version) Equals(other Version) bool {
 	return v.Ver == other.Ver && v.Rel == other.Rel && v.Epoch == other.Epoch
 }
 
+// nevra returns the Name-Epoch:Version-Release.Arch string identifying a package with this version.
+// A missing epoch is treated as epoch 0.
+func (v Version) nevra(name, arch string) string {
+	epoch := v.Epoch
+	if epoch == "" {
+		epoch = "0"
+	}
+	return fmt.Sprintf("%s-%s:%s-%s.%s", name, epoch, v.Ver, v.Rel, arch)
+}
+
 type PackageChecksum struct {
-	PkgId string `xml:"pkgid,attr"`
 	Checksum
+	PkgId string `xml:"pkgid,attr"`
+}
+
+type Time struct {
+	File  int64 `xml:"file,attr"`
+	Build int64 `xml:"build,attr"`
+}
+
+// HeaderRange is the byte range of the RPM header within the package file.
+type HeaderRange struct {
+	Start int64 `xml:"start,attr"`
+	End   int64 `xml:"end,attr"`
+}
+
+// Entry is a single provides, requires, conflicts or obsoletes dependency of a package.
+type Entry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+	Pre   string `xml:"pre,attr,omitempty"`
+}
+
+// Entries is a list of dependencies, omitted entirely from a Format when empty.
+type Entries []Entry
+
+type entries struct {
+	Entries []Entry `xml:"rpm:entry"`
+}
+
+func (e Entries) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return enc.EncodeElement(entries{Entries: e}, start)
+}
+
+func (e *Entries) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v entries
+	err := d.DecodeElement(&v, &start)
+	if err != nil {
+		return err
+	}
+	*e = v.Entries
+	return nil
+}
+
+// Format is the RPM specific information of a package.
+// Elements in the rpm namespace are written with the rpm: prefix declared by the root element.
+// Elements not modelled here, such as the weak dependencies rpm:recommends and rpm:suggests,
+// are written after the dependencies and before the file list as createrepo does.
+type Format struct {
+	License     string      `xml:"rpm:license"`
+	Vendor      string      `xml:"rpm:vendor"`
+	Group       string      `xml:"rpm:group"`
+	BuildHost   string      `xml:"rpm:buildhost"`
+	SourceRPM   string      `xml:"rpm:sourcerpm"`
+	HeaderRange HeaderRange `xml:"rpm:header-range"`
+	Provides    Entries     `xml:"rpm:provides,omitempty"`
+	Requires    Entries     `xml:"rpm:requires,omitempty"`
+	Conflicts   Entries     `xml:"rpm:conflicts,omitempty"`
+	Obsoletes   Entries     `xml:"rpm:obsoletes,omitempty"`
+	Unknown     []Element   `xml:",any"`
+	Files       []File      `xml:"file"`
+}
+
+func (f *Format) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type format Format
+	return decodePrefixed(d, start, (*format)(f))
 }
 
 type Package struct {
-	Type     string          `xml:"type,attr"`
-	Name     string          `xml:"name"`
-	Arch     string          `xml:"arch"`
-	Version  Version         `xml:"version"`
-	Location Location        `xml:"location"`
-	Size     Size            `xml:"size"`
-	Checksum PackageChecksum `xml:"checksum"`
+	Type        string          `xml:"type,attr"`
+	Name        string          `xml:"name"`
+	Arch        string          `xml:"arch"`
+	Version     Version         `xml:"version"`
+	Checksum    PackageChecksum `xml:"checksum"`
+	Summary     string          `xml:"summary"`
+	Description string          `xml:"description"`
+	Packager    string          `xml:"packager"`
+	URL         string          `xml:"url"`
+	Time        Time            `xml:"time"`
+	Size        Size            `xml:"size"`
+	Location    Location        `xml:"location"`
+	Format      Format          `xml:"format"`
+	Unknown     []Element       `xml:",any"`
 }
 
 // Equals returns true if this PackageData is equal to another in terms of Architecture and Version
@@ -34,24 +118,72 @@ func (p Package) Equals(other Package) bool {
 	return p.Name == other.Name && p.Arch == other.Arch && p.Version.Equals(other.Version)
 }
 
+// NEVRA returns the Name-Epoch:Version-Release.Arch string uniquely identifying this package in a repository.
+func (p Package) NEVRA() string {
+	return p.Version.nevra(p.Name, p.Arch)
+}
+
 type PackageData struct {
 	XMLName      xml.Name
 	PackageCount int       `xml:"packages,attr"`
 	Packages     []Package `xml:"package"`
+	Unknown      []Element `xml:",any"`
+
+	// index of Packages by NEVRA
+	index map[string]int
+	// sorted is the number of leading Packages known to be in canonical order, packages added since are sorted when encoded
+	sorted int
+}
+
+// MarshalXML encodes the package list as a primary.xml document with the common and rpm namespaces,
+// regardless of the namespace the package list was decoded from. Packages are written in canonical order.
+func (pd PackageData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type packageData PackageData
+	pd.Sort()
+	pd.XMLName = xml.Name{}
+	return e.EncodeElement(packageData(pd), primaryRoot())
+}
+
+// reindex indexes the package list, sorting it first unless it is already in canonical order,
+// e.g. as decoded from metadata written by this package.
+func (pd *PackageData) reindex() {
+	pd.Sort()
+
+	pd.index = make(map[string]int, len(pd.Packages))
+	for i := len(pd.Packages) - 1; i >= 0; i-- {
+		pd.index[pd.Packages[i].NEVRA()] = i
+	}
+}
+
+// Get returns the package with the given NEVRA.
+func (pd *PackageData) Get(nevra string) (Package, bool) {
+	if pd.index == nil {
+		pd.reindex()
+	}
+	i, ok := pd.index[nevra]
+	if !ok {
+		return Package{}, false
+	}
+	return pd.Packages[i], true
 }
 
 // Add a Package to this package list or updates an existing one if the checksum isn't equal.
 // Returns true if the package list was updated.
 func (pd *PackageData) Add(pkg Package) bool {
-	for i, f := range pd.Packages {
-		if pkg.Equals(f) {
-			if !pkg.Checksum.Equals(f.Checksum.Checksum) {
-				pd.Packages[i] = pkg
-				return true
-			}
-			return false
+	if pd.index == nil {
+		pd.reindex()
+	}
+
+	nevra := pkg.NEVRA()
+	if i, ok := pd.index[nevra]; ok {
+		if !pkg.Checksum.Equals(pd.Packages[i].Checksum.Checksum) {
+			pd.Packages[i] = pkg
+			return true
 		}
+		return false
 	}
+
+	pd.index[nevra] = len(pd.Packages)
 	pd.Packages = append(pd.Packages, pkg)
 	pd.PackageCount = len(pd.Packages)
 	return true
@@ -0,0 +1,56 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// encodeDocument encodes v as a metadata document the way it is uploaded.
+func encodeDocument(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	return encodeStream(t, func(e *xml.Encoder) error {
+		return e.Encode(v)
+	})
+}
+
+// encodeStream returns the metadata document written to the encoder by f, as it is uploaded.
+func encodeStream(t *testing.T, f func(e *xml.Encoder) error) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	mw := NewMetadataWriter(&b)
+	_, _ = io.WriteString(mw, xml.Header)
+	err := f(xml.NewEncoder(mw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestPackageDataRoundTrip(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/roundtrip-primary.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pd PackageData
+	err = xml.Unmarshal(want, &pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pd.Packages) != 1 || len(pd.Packages[0].Format.Unknown) != 2 {
+		t.Fatalf("decoded %d packages", len(pd.Packages))
+	}
+
+	// weak dependencies are not modelled but are kept in place, before the file list
+	got := encodeDocument(t, pd)
+	if !bytes.Equal(got, want) {
+		t.Errorf("round trip differs:\n%s\nwant:\n%s", got, want)
+	}
+}
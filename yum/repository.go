@@ -1,5 +1,7 @@
 package yum
 
+// Repository is a repository loaded into memory, including the file list of every package.
+// Merge updates a repository without loading it, for repositories too large to hold in memory.
 type Repository struct {
 	Metadata *MetadataData
 	Packages *PackageData
@@ -7,18 +9,25 @@ type Repository struct {
 }
 
 // Update updates this repository with a given RPMObject.
+// Packages are looked up by NEVRA and pkgid so that updating a large repository is linear in the size of the batch.
+// New packages are appended and only put in canonical order when the repository is encoded,
+// so that the same set of packages always produces the same metadata.
 func (repo *Repository) Update(objects ...*RPMObject) bool {
-	var packages bool
-	var filelist bool
+	var updated bool
 	for _, f := range objects {
-		ok := repo.Packages.Add(f.Package())
-		if ok {
-			packages = true
+		pkg := f.Package()
+		prev, exists := repo.Packages.Get(pkg.NEVRA())
+		if !repo.Packages.Add(pkg) {
+			continue
 		}
-		ok = repo.Filelist.Add(f.Filelist())
-		if ok {
-			filelist = true
+
+		updated = true
+		if exists {
+			repo.Filelist.Replace(prev.Checksum.Checksum.Checksum, f.Filelist())
+		} else {
+			repo.Filelist.Add(f.Filelist())
 		}
 	}
-	return packages || filelist
+
+	return updated
 }
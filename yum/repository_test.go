@@ -0,0 +1,211 @@
+package yum
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/rustylynch/go-rpmutils"
+	"testing"
+)
+
+func newTestRPM(name, version, release, checksum string) *RPMObject {
+	return &RPMObject{
+		Key: fmt.Sprintf("%s-%s-%s.x86_64.rpm", name, version, release),
+		RPM: RPM{
+			Release: &rpmutils.NEVRA{
+				Name:    name,
+				Epoch:   "0",
+				Version: version,
+				Release: release,
+				Arch:    "x86_64",
+			},
+			Checksum:  Checksum{Type: "sha256", Checksum: checksum},
+			Files:     []File{{Name: "/usr/bin/" + name}, {Name: "/usr/share/doc/" + name + "/README"}},
+			BuildTime: 1500000000,
+		},
+	}
+}
+
+func newTestRepository() *Repository {
+	return &Repository{
+		Metadata: &MetadataData{},
+		Packages: &PackageData{},
+		Filelist: &FilelistData{},
+	}
+}
+
+// encodedOrder returns the NEVRAs of the packages in primary and filelists, in the order they are encoded.
+func encodedOrder(t *testing.T, repo *Repository) ([]string, []string) {
+	t.Helper()
+
+	var primary, filelists bytes.Buffer
+	err := xml.NewEncoder(&primary).Encode(repo.Packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xml.NewEncoder(&filelists).Encode(repo.Filelist)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pd PackageData
+	err = xml.Unmarshal(primary.Bytes(), &pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fl FilelistData
+	err = xml.Unmarshal(filelists.Bytes(), &fl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packages, files []string
+	for _, p := range pd.Packages {
+		packages = append(packages, p.NEVRA())
+	}
+	for _, f := range fl.Packages {
+		files = append(files, f.Version.nevra(f.Name, f.Arch))
+	}
+	return packages, files
+}
+
+func TestRepositoryUpdateOrder(t *testing.T) {
+	repo := newTestRepository()
+	repo.Update(
+		newTestRPM("zlib", "1.2.7", "1", "c1"),
+		newTestRPM("bash", "4.2", "1", "c2"),
+	)
+	repo.Update(
+		newTestRPM("bash", "4.10", "1", "c3"),
+		newTestRPM("curl", "7.29", "1", "c4"),
+	)
+	// a package replaced in place keeps its position
+	repo.Update(newTestRPM("zlib", "1.2.7", "1", "c5"))
+
+	want := []string{"bash-0:4.2-1.x86_64", "bash-0:4.10-1.x86_64", "curl-0:7.29-1.x86_64", "zlib-0:1.2.7-1.x86_64"}
+	packages, files := encodedOrder(t, repo)
+	if fmt.Sprint(packages) != fmt.Sprint(want) {
+		t.Errorf("primary order %v, want %v", packages, want)
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("filelists order %v, want %v", files, want)
+	}
+
+	// encoding does not reorder the repository
+	if repo.Packages.Packages[0].Name != "zlib" || repo.Filelist.Packages[0].Name != "zlib" {
+		t.Errorf("encoding reordered the repository")
+	}
+
+	// packages are still found once sorted
+	repo.Packages.Sort()
+	if repo.Packages.Packages[0].Name != "bash" || repo.Packages.Packages[3].Name != "zlib" {
+		t.Errorf("repository not sorted: %v", repo.Packages.Packages)
+	}
+	if p, ok := repo.Packages.Get("zlib-0:1.2.7-1.x86_64"); !ok || p.Checksum.Checksum.Checksum != "c5" {
+		t.Errorf("replaced package: %+v, %t", p.Checksum, ok)
+	}
+}
+
+func TestCanonicalOrder(t *testing.T) {
+	values := []int{1, 3, 5, 7, 4, 0, 7, 9}
+	order := canonicalOrder(len(values), 4, func(i, j int) bool { return values[i] < values[j] })
+
+	var sorted []int
+	for _, i := range order {
+		sorted = append(sorted, values[i])
+	}
+	if fmt.Sprint(sorted) != "[0 1 3 4 5 7 7 9]" {
+		t.Errorf("got %v", sorted)
+	}
+	// equal items keep the sorted items first
+	if order[5] != 3 || order[6] != 6 {
+		t.Errorf("unstable order %v", order)
+	}
+}
+
+// benchmarkRepository returns a repository of n packages.
+func benchmarkRepository(n int) *Repository {
+	repo := newTestRepository()
+	objects := make([]*RPMObject, n)
+	for i := range objects {
+		objects[i] = newTestRPM(fmt.Sprintf("package%06d", i), "1.0", "1", fmt.Sprintf("c%d", i))
+	}
+	repo.Update(objects...)
+	repo.Packages.Sort()
+	repo.Filelist.Sort()
+	return repo
+}
+
+// linearUpdate updates a repository as Repository.Update did before packages were indexed,
+// scanning the package list and filelist for every package of the batch.
+func linearUpdate(repo *Repository, objects ...*RPMObject) bool {
+	var packages, filelist bool
+	for _, f := range objects {
+		pkg := f.Package()
+		found := false
+		for i, p := range repo.Packages.Packages {
+			if pkg.Equals(p) {
+				found = true
+				if !pkg.Checksum.Equals(p.Checksum.Checksum) {
+					repo.Packages.Packages[i] = pkg
+					packages = true
+				}
+				break
+			}
+		}
+		if !found {
+			repo.Packages.Packages = append(repo.Packages.Packages, pkg)
+			repo.Packages.PackageCount = len(repo.Packages.Packages)
+			packages = true
+		}
+
+		// the filelist of a package is always replaced or added
+		fl := f.Filelist()
+		found = false
+		for i, p := range repo.Filelist.Packages {
+			if p.PkgID == fl.PkgID {
+				repo.Filelist.Packages[i] = fl
+				found = true
+				break
+			}
+		}
+		if !found {
+			repo.Filelist.Packages = append(repo.Filelist.Packages, fl)
+			repo.Filelist.PackageCount = len(repo.Filelist.Packages)
+		}
+		filelist = true
+	}
+	return packages || filelist
+}
+
+// BenchmarkRepositoryUpdate updates a repository of 100k packages with a batch of 100 packages,
+// half of them replacing existing packages and half of them new.
+// The linear benchmark is the update before packages were indexed, for comparison.
+func BenchmarkRepositoryUpdate(b *testing.B) {
+	for _, c := range []struct {
+		name   string
+		update func(repo *Repository, objects ...*RPMObject) bool
+	}{
+		{"indexed", (*Repository).Update},
+		{"linear", linearUpdate},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			repo := benchmarkRepository(100000)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				batch := make([]*RPMObject, 100)
+				for j := range batch {
+					name := fmt.Sprintf("package%06d", j*1000)
+					if j%2 == 1 {
+						name = fmt.Sprintf("new%d-%03d", i, j)
+					}
+					batch[j] = newTestRPM(name, "1.0", "1", fmt.Sprintf("u%d-%d", i, j))
+				}
+				if !c.update(repo, batch...) {
+					b.Fatal("repository not updated")
+				}
+			}
+		})
+	}
+}
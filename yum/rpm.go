@@ -8,16 +8,23 @@ import (
 )
 
 type Size struct {
-	Archive   int64 `xml:"archive,attr"`
 	Package   int64 `xml:"package,attr"`
 	Installed int64 `xml:"installed,attr"`
+	Archive   int64 `xml:"archive,attr"`
 }
 
 type RPM struct {
-	Release  *rpmutils.NEVRA
-	Checksum Checksum
-	Size     Size
-	Files    []rpmutils.FileInfo
+	Release     *rpmutils.NEVRA `json:"release"`
+	Checksum    Checksum        `json:"checksum"`
+	Size        Size            `json:"size"`
+	Files       []File          `json:"files"`
+	Summary     string          `json:"summary"`
+	Description string          `json:"description"`
+	Packager    string          `json:"packager"`
+	URL         string          `json:"url"`
+	BuildTime   int64           `json:"build_time"`
+	FileTime    int64           `json:"file_time"`
+	Format      Format          `json:"format"`
 }
 
 type RPMObject struct {
@@ -26,6 +33,14 @@ type RPMObject struct {
 }
 
 func (f *RPMObject) Package() Package {
+	format := f.Format
+	format.Files = nil
+	for _, fn := range f.Files {
+		if isPrimaryFile(fn.Name) {
+			format.Files = append(format.Files, fn)
+		}
+	}
+
 	return Package{
 		Type: "rpm",
 		Name: f.Release.Name,
@@ -35,28 +50,32 @@ func (f *RPMObject) Package() Package {
 			Rel:   f.Release.Release,
 			Ver:   f.Release.Version,
 		},
-		Size: f.Size,
-		Location: Location{
-			Href: f.Key,
-		},
 		Checksum: PackageChecksum{
 			PkgId:    "YES",
 			Checksum: f.Checksum,
 		},
+		Summary:     f.Summary,
+		Description: f.Description,
+		Packager:    f.Packager,
+		URL:         f.URL,
+		Time: Time{
+			File:  f.FileTime,
+			Build: f.BuildTime,
+		},
+		Size: f.Size,
+		Location: Location{
+			Href: f.Key,
+		},
+		Format: format,
 	}
 }
 
 func (f *RPMObject) Filelist() Filelist {
-	files := make([]string, len(f.Files))
-	for i, fn := range f.Files {
-		files[i] = fn.Name()
-	}
-
 	return Filelist{
 		PkgID: f.Checksum.Checksum,
 		Name:  f.RPM.Release.Name,
 		Arch:  f.RPM.Release.Arch,
-		Files: files,
+		Files: f.Files,
 		Version: Version{
 			Epoch: f.Release.Epoch,
 			Ver:   f.Release.Version,
@@ -65,24 +84,49 @@ func (f *RPMObject) Filelist() Filelist {
 	}
 }
 
+// ProgressFunc is called with the total number of bytes hashed so far while scanning an RPM.
+type ProgressFunc func(n int64)
+
 type byteCounter struct {
-	count int
+	count    int64
+	progress []ProgressFunc
 }
 
-func (bc *byteCounter) Size() int {
+func (bc *byteCounter) Size() int64 {
 	return bc.count
 }
 
 func (bc *byteCounter) Write(b []byte) (int, error) {
-	bc.count += len(b)
+	bc.count += int64(len(b))
+	for _, f := range bc.progress {
+		f(bc.count)
+	}
 	return len(b), nil
 }
 
-func ScanRPM(ctx context.Context, data io.Reader) (*RPM, error) {
+// contextReader aborts reading from the underlying reader once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(b []byte) (int, error) {
+	err := cr.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	return cr.r.Read(b)
+}
+
+// ScanRPM reads an RPM file from data, calculating its checksum and size and extracting its header information.
+// Reading stops with the context error as soon as ctx is done.
+// Any given progress functions are called with the number of bytes hashed after each read.
+// FileTime is not part of the RPM and is left for the caller to set to the modification time of the file.
+func ScanRPM(ctx context.Context, data io.Reader, progress ...ProgressFunc) (*RPM, error) {
 	var (
 		checksum = SHA256()
-		bc       = new(byteCounter)
-		r        = io.TeeReader(data, io.MultiWriter(bc, checksum))
+		bc       = &byteCounter{progress: progress}
+		r        = io.TeeReader(&contextReader{ctx: ctx, r: data}, io.MultiWriter(bc, checksum))
 	)
 
 	rpm, err := rpmutils.ReadHeader(r)
@@ -90,16 +134,30 @@ func ScanRPM(ctx context.Context, data io.Reader) (*RPM, error) {
 		return nil, err
 	}
 
+	// the header is read exactly, so everything hashed so far is the lead, signature and header
+	headerRange := HeaderRange{
+		Start: int64(rpm.OriginalSignatureHeaderSize()),
+		End:   bc.Size(),
+	}
+
 	release, err := rpm.GetNEVRA()
 	if err != nil {
 		return nil, err
 	}
 
-	files, err := rpm.GetFiles()
+	info, err := rpm.GetFiles()
 	if err != nil {
 		return nil, err
 	}
 
+	files := make([]File, len(info))
+	for i, fi := range info {
+		files[i] = File{
+			Name: fi.Name(),
+			Type: fileType(fi),
+		}
+	}
+
 	installed, err := rpm.InstalledSize()
 	if err != nil {
 		return nil, err
@@ -110,20 +168,67 @@ func ScanRPM(ctx context.Context, data io.Reader) (*RPM, error) {
 		return nil, err
 	}
 
+	result := &RPM{
+		Release: release,
+		Files:   files,
+		Format: Format{
+			HeaderRange: headerRange,
+		},
+	}
+
+	fields := []struct {
+		tag int
+		v   *string
+	}{
+		{rpmutils.SUMMARY, &result.Summary},
+		{rpmutils.DESCRIPTION, &result.Description},
+		{rpmutils.PACKAGER, &result.Packager},
+		{rpmutils.URL, &result.URL},
+		{rpmutils.LICENSE, &result.Format.License},
+		{rpmutils.VENDOR, &result.Format.Vendor},
+		{rpmutils.GROUP, &result.Format.Group},
+		{rpmutils.BUILDHOST, &result.Format.BuildHost},
+		{rpmutils.SOURCERPM, &result.Format.SourceRPM},
+	}
+	for _, s := range fields {
+		*s.v, err = headerString(rpm, s.tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.BuildTime, err = headerInt(rpm, rpmutils.BUILDTIME)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := []struct {
+		name, flags, version int
+		v                    *Entries
+	}{
+		{rpmutils.PROVIDENAME, rpmutils.PROVIDEFLAGS, rpmutils.PROVIDEVERSION, &result.Format.Provides},
+		{rpmutils.REQUIRENAME, rpmutils.REQUIREFLAGS, rpmutils.REQUIREVERSION, &result.Format.Requires},
+		{tagConflictName, tagConflictFlags, tagConflictVersion, &result.Format.Conflicts},
+		{rpmutils.OBSOLETENAME, rpmutils.OBSOLETEFLAGS, rpmutils.OBSOLETEVERSION, &result.Format.Obsoletes},
+	}
+	for _, d := range dependencies {
+		*d.v, err = headerEntries(rpm, d.name, d.flags, d.version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// ensure any remaining data is consumed to the sha1 writer
 	_, err = io.Copy(ioutil.Discard, r)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RPM{
-		Release: release,
-		Files:   files,
-		Size: Size{
-			Package:   int64(bc.Size()),
-			Archive:   payload,
-			Installed: installed,
-		},
-		Checksum: checksum.Sum(),
-	}, nil
+	result.Size = Size{
+		Package:   bc.Size(),
+		Archive:   payload,
+		Installed: installed,
+	}
+	result.Checksum = checksum.Sum()
+	return result, nil
 }
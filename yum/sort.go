@@ -0,0 +1,156 @@
+package yum
+
+import (
+	"github.com/rustylynch/go-rpmutils"
+	"sort"
+	"strings"
+)
+
+// sortKey orders packages canonically by name, arch then EVR.
+// Packages with an equal NEVRA are ordered by their pkgid so that the order is always deterministic.
+type sortKey struct {
+	Name    string
+	Arch    string
+	Version Version
+	PkgID   string
+}
+
+func epochOrZero(epoch string) string {
+	if epoch == "" {
+		return "0"
+	}
+	return epoch
+}
+
+func compareVersion(a, b Version) int {
+	if c := rpmutils.Vercmp(epochOrZero(a.Epoch), epochOrZero(b.Epoch)); c != 0 {
+		return c
+	}
+	if c := rpmutils.Vercmp(a.Ver, b.Ver); c != 0 {
+		return c
+	}
+	return rpmutils.Vercmp(a.Rel, b.Rel)
+}
+
+func (k sortKey) Less(other sortKey) bool {
+	if c := strings.Compare(k.Name, other.Name); c != 0 {
+		return c < 0
+	}
+	if c := strings.Compare(k.Arch, other.Arch); c != 0 {
+		return c < 0
+	}
+	if c := compareVersion(k.Version, other.Version); c != 0 {
+		return c < 0
+	}
+	return k.PkgID < other.PkgID
+}
+
+func (p Package) sortKey() sortKey {
+	return sortKey{Name: p.Name, Arch: p.Arch, Version: p.Version, PkgID: p.Checksum.Checksum.Checksum}
+}
+
+func (f Filelist) sortKey() sortKey {
+	return sortKey{Name: f.Name, Arch: f.Arch, Version: f.Version, PkgID: f.PkgID}
+}
+
+func (f *RPMObject) sortKey() sortKey {
+	return sortKey{
+		Name: f.Release.Name,
+		Arch: f.Release.Arch,
+		Version: Version{
+			Epoch: f.Release.Epoch,
+			Ver:   f.Release.Version,
+			Rel:   f.Release.Release,
+		},
+		PkgID: f.Checksum.Checksum,
+	}
+}
+
+// sortedPrefix returns the number of leading items of n items that are in canonical order, given that the first sorted are.
+func sortedPrefix(n, sorted int, less func(i, j int) bool) int {
+	if sorted == 0 && n > 0 {
+		sorted = 1
+	}
+	for sorted < n && !less(sorted, sorted-1) {
+		sorted++
+	}
+	return sorted
+}
+
+// canonicalOrder returns the indices of n items in canonical order, given that the first sorted items are already in order.
+// Only the items after them are sorted and then merged into the sorted items,
+// so that ordering a few items added to a large sorted list is linear in the size of the list.
+func canonicalOrder(n, sorted int, less func(i, j int) bool) []int {
+	tail := make([]int, 0, n-sorted)
+	for i := sorted; i < n; i++ {
+		tail = append(tail, i)
+	}
+	sort.SliceStable(tail, func(a, b int) bool {
+		return less(tail[a], tail[b])
+	})
+
+	order := make([]int, 0, n)
+	var i int
+	for _, t := range tail {
+		for i < sorted && !less(t, i) {
+			order = append(order, i)
+			i++
+		}
+		order = append(order, t)
+	}
+	for ; i < sorted; i++ {
+		order = append(order, i)
+	}
+	return order
+}
+
+func (pd *PackageData) less(i, j int) bool {
+	return pd.Packages[i].sortKey().Less(pd.Packages[j].sortKey())
+}
+
+func (fl *FilelistData) less(i, j int) bool {
+	return fl.Packages[i].sortKey().Less(fl.Packages[j].sortKey())
+}
+
+// Sort sorts the package list canonically by name, arch and EVR.
+func (pd *PackageData) Sort() {
+	pd.sorted = sortedPrefix(len(pd.Packages), pd.sorted, pd.less)
+	if pd.sorted == len(pd.Packages) {
+		return
+	}
+	order := canonicalOrder(len(pd.Packages), pd.sorted, pd.less)
+	packages := make([]Package, len(order))
+	for i, j := range order {
+		packages[i] = pd.Packages[j]
+	}
+	pd.Packages = packages
+	pd.sorted = len(packages)
+	pd.index = nil
+}
+
+// Sort sorts the filelist canonically by name, arch and EVR, in the same order as PackageData.Sort.
+func (fl *FilelistData) Sort() {
+	fl.sorted = sortedPrefix(len(fl.Packages), fl.sorted, fl.less)
+	if fl.sorted == len(fl.Packages) {
+		return
+	}
+	order := canonicalOrder(len(fl.Packages), fl.sorted, fl.less)
+	packages := make([]Filelist, len(order))
+	for i, j := range order {
+		packages[i] = fl.Packages[j]
+	}
+	fl.Packages = packages
+	fl.sorted = len(packages)
+	fl.index = nil
+}
+
+// BuildTime returns the newest build time of all packages in the package list.
+func (pd *PackageData) BuildTime() int64 {
+	var t int64
+	for _, p := range pd.Packages {
+		if p.Time.Build > t {
+			t = p.Time.Build
+		}
+	}
+	return t
+}
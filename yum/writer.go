@@ -0,0 +1,255 @@
+package yum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// MetadataWriter re-formats a metadata document written to it by an xml.Encoder into a layout modelled on createrepo_c's output:
+//   - <package> elements of the root are written at the start of the line, any other element is indented by two spaces per level
+//   - elements with attributes and no content are closed with />, other elements without content and the root with an end tag
+//   - elements with text content are written on one line, without indenting any elements within them
+//   - text and attribute values are escaped as libxml2 escapes them, without escaping quotes or newlines in text
+//   - the document ends with a newline
+//
+// The document must be written unindented. Close must be called to finish writing the document.
+type MetadataWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// NewMetadataWriter creates a new MetadataWriter writing the re-formatted document to w.
+func NewMetadataWriter(w io.Writer) *MetadataWriter {
+	pr, pw := io.Pipe()
+	mw := &MetadataWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		p := &printer{w: bufio.NewWriter(w)}
+		err := p.print(xml.NewDecoder(pr))
+		if err == nil {
+			err = p.w.Flush()
+		}
+		// unblock any further writes if the document cannot be re-formatted
+		_ = pr.CloseWithError(err)
+		mw.done <- err
+	}()
+
+	return mw
+}
+
+func (mw *MetadataWriter) Write(b []byte) (int, error) {
+	return mw.pw.Write(b)
+}
+
+// Close finishes writing the document, returning any error re-formatting it.
+func (mw *MetadataWriter) Close() error {
+	_ = mw.pw.Close()
+	return <-mw.done
+}
+
+type printer struct {
+	w *bufio.Writer
+
+	depth int
+	// pending is the last start element, which is written once it is known whether it has content
+	pending *xml.StartElement
+	// text is the depth of the element whose text content stops indentation, 0 if none
+	text int
+	// flat is true within a <package> element of the root
+	flat bool
+}
+
+func (p *printer) print(d *xml.Decoder) error {
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF && p.depth > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tt := t.(type) {
+		case xml.StartElement:
+			p.start(tt)
+		case xml.EndElement:
+			p.end(tt)
+		case xml.CharData:
+			p.charData(tt)
+		case xml.ProcInst:
+			p.content()
+			p.w.WriteString("<?" + tt.Target + " " + string(tt.Inst) + "?>")
+			if p.depth == 0 {
+				p.w.WriteByte('\n')
+			}
+		case xml.Comment:
+			p.content()
+			p.indent()
+			p.w.WriteString("<!--" + string(tt) + "-->")
+			if p.depth == 0 {
+				p.w.WriteByte('\n')
+			}
+		}
+	}
+}
+
+// level returns the indentation level of an element at depth.
+func (p *printer) level(depth int) int {
+	level := depth - 1
+	if p.flat {
+		level--
+	}
+	return level
+}
+
+// indent starts a new line indented for an element at the next depth, unless indentation is stopped by text.
+func (p *printer) indent() {
+	if p.text > 0 || p.depth == 0 {
+		return
+	}
+	p.w.WriteByte('\n')
+	p.w.WriteString(strings.Repeat("  ", p.level(p.depth+1)))
+}
+
+// content writes the pending start element once it is known to have content.
+func (p *printer) content() {
+	if p.pending == nil {
+		return
+	}
+	p.writeStart(*p.pending)
+	p.w.WriteByte('>')
+	p.pending = nil
+}
+
+func (p *printer) start(start xml.StartElement) {
+	p.content()
+	if p.depth == 1 && start.Name.Space == "" && start.Name.Local == "package" {
+		p.flat = true
+	}
+	p.indent()
+	p.depth++
+	start = start.Copy()
+	p.pending = &start
+}
+
+func (p *printer) end(end xml.EndElement) {
+	switch {
+	case p.pending != nil && len(p.pending.Attr) > 0 && p.depth > 1:
+		p.writeStart(*p.pending)
+		p.w.WriteString("/>")
+		p.pending = nil
+	case p.pending != nil && p.depth > 1:
+		p.content()
+		p.writeEnd(end)
+	case p.text > 0:
+		p.writeEnd(end)
+	default:
+		// the root element is never written as an empty element
+		p.content()
+		p.w.WriteByte('\n')
+		p.w.WriteString(strings.Repeat("  ", p.level(p.depth)))
+		p.writeEnd(end)
+	}
+
+	if p.text == p.depth {
+		p.text = 0
+	}
+	p.depth--
+	switch p.depth {
+	case 0:
+		p.w.WriteByte('\n')
+	case 1:
+		p.flat = false
+	}
+}
+
+func (p *printer) charData(cd xml.CharData) {
+	if p.depth == 0 {
+		return
+	}
+	if p.pending == nil && p.text == 0 && len(bytes.TrimSpace(cd)) == 0 {
+		// whitespace between elements is replaced by indentation
+		return
+	}
+
+	p.content()
+	if p.text == 0 {
+		p.text = p.depth
+	}
+	escapeText(p.w, cd)
+}
+
+func (p *printer) writeStart(start xml.StartElement) {
+	p.w.WriteByte('<')
+	writeName(p.w, start.Name)
+	for _, a := range start.Attr {
+		p.w.WriteByte(' ')
+		writeName(p.w, a.Name)
+		p.w.WriteString(`="`)
+		escapeAttr(p.w, a.Value)
+		p.w.WriteByte('"')
+	}
+}
+
+func (p *printer) writeEnd(end xml.EndElement) {
+	p.w.WriteString("</")
+	writeName(p.w, end.Name)
+	p.w.WriteByte('>')
+}
+
+func writeName(w *bufio.Writer, n xml.Name) {
+	if n.Space != "" {
+		w.WriteString(n.Space)
+		w.WriteByte(':')
+	}
+	w.WriteString(n.Local)
+}
+
+func escapeText(w *bufio.Writer, b []byte) {
+	for _, c := range b {
+		switch c {
+		case '<':
+			w.WriteString("&lt;")
+		case '>':
+			w.WriteString("&gt;")
+		case '&':
+			w.WriteString("&amp;")
+		case '\r':
+			w.WriteString("&#13;")
+		default:
+			w.WriteByte(c)
+		}
+	}
+}
+
+func escapeAttr(w *bufio.Writer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '<':
+			w.WriteString("&lt;")
+		case '>':
+			w.WriteString("&gt;")
+		case '&':
+			w.WriteString("&amp;")
+		case '"':
+			w.WriteString("&quot;")
+		case '\n':
+			w.WriteString("&#10;")
+		case '\r':
+			w.WriteString("&#13;")
+		case '\t':
+			w.WriteString("&#9;")
+		default:
+			w.WriteByte(c)
+		}
+	}
+}
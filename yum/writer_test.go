@@ -0,0 +1,57 @@
+package yum
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestMetadataWriter(t *testing.T) {
+	for _, c := range []struct {
+		name, in, want string
+	}{
+		{
+			"empty root",
+			`<metadata packages="0"></metadata>`,
+			"<metadata packages=\"0\">\n</metadata>\n",
+		},
+		{
+			"nested elements",
+			`<repomd><data type="x"><location href="a&amp;b&#34;c&#xA;"></location><size>1</size></data><tags></tags></repomd>`,
+			"<repomd>\n  <data type=\"x\">\n    <location href=\"a&amp;b&quot;c&#10;\"/>\n    <size>1</size>\n  </data>\n  <tags></tags>\n</repomd>\n",
+		},
+		{
+			"packages of the root",
+			`<filelists><package pkgid="1"><file>a</file></package><other><file>b</file></other></filelists>`,
+			"<filelists>\n<package pkgid=\"1\">\n  <file>a</file>\n</package>\n  <other>\n    <file>b</file>\n  </other>\n</filelists>\n",
+		},
+		{
+			"text",
+			`<metadata><description>&#34;a&#34; &amp; &lt;b&gt;&#xA;c&#xD;</description><mixed>a <b x="1"></b> <c>d</c></mixed></metadata>`,
+			"<metadata>\n  <description>\"a\" &amp; &lt;b&gt;\nc&#13;</description>\n  <mixed>a <b x=\"1\"/> <c>d</c></mixed>\n</metadata>\n",
+		},
+	} {
+		var b bytes.Buffer
+		mw := NewMetadataWriter(&b)
+		_, err := io.WriteString(mw, c.in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		err = mw.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if b.String() != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, b.String(), c.want)
+		}
+	}
+}
+
+func TestMetadataWriterInvalid(t *testing.T) {
+	var b bytes.Buffer
+	mw := NewMetadataWriter(&b)
+	_, _ = io.WriteString(mw, `<metadata><package>`)
+	if mw.Close() != io.ErrUnexpectedEOF {
+		t.Error("incomplete document was written")
+	}
+}